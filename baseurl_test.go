@@ -0,0 +1,55 @@
+package githubfs_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-github/github"
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/progrium/go-githubfs/githubfstest"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("request sent through the client given to New")
+}
+
+func TestBaseURL(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 200000)
+	srv := githubfstest.NewServer()
+	defer srv.Close()
+	srv.CreateRepo("o", "r", "master", map[string]string{"big.bin": string(big), "small": "s"})
+	c := &countingTransport{}
+	client := github.NewClient(&http.Client{Transport: failingTransport{}})
+	baseURL := client.BaseURL.String()
+	fs, err := githubfs.New(client, "o", "r",
+		githubfs.WithBaseURL(srv.URL, &http.Client{Transport: c}),
+		githubfs.WithStreaming(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL.String() != baseURL {
+		t.Fatalf("client BaseURL changed to %s", client.BaseURL)
+	}
+	if got := readFile(t, fs, "small"); got != "s" {
+		t.Fatalf("read %q", got)
+	}
+
+	f, err := fs.Open("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	read := atomic.LoadInt64(&c.bytes)
+	buf := make([]byte, 5)
+	if n, err := f.ReadAt(buf, 1234); n != 5 || err != nil || string(buf) != "45678" {
+		t.Fatalf("read at: %d %v %q", n, err, buf)
+	}
+	if n := atomic.LoadInt64(&c.bytes) - read; n >= int64(len(big))/2 {
+		t.Fatalf("read of 5 bytes downloaded %d bytes", n)
+	}
+}
@@ -0,0 +1,134 @@
+package githubfs
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BlobCache stores blob contents keyed by their SHA. Since blobs are
+// content-addressed, cached entries never go stale. Implementations must be
+// safe for concurrent use.
+type BlobCache interface {
+	Get(sha string) ([]byte, bool)
+	Add(sha string, data []byte)
+}
+
+// LRUBlobCache is an in-memory BlobCache that evicts the least recently
+// used blobs once their total size exceeds a limit.
+type LRUBlobCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	sha  string
+	data []byte
+}
+
+// NewLRUBlobCache returns an LRUBlobCache holding at most maxBytes of blob
+// contents.
+func NewLRUBlobCache(maxBytes int64) *LRUBlobCache {
+	return &LRUBlobCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUBlobCache) Get(sha string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[sha]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return append([]byte(nil), el.Value.(*lruEntry).data...), true
+}
+
+func (c *LRUBlobCache) Add(sha string, data []byte) {
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[sha]; ok {
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[sha] = c.ll.PushFront(&lruEntry{sha: sha, data: append([]byte(nil), data...)})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		el := c.ll.Back()
+		e := el.Value.(*lruEntry)
+		c.ll.Remove(el)
+		delete(c.items, e.sha)
+		c.size -= int64(len(e.data))
+	}
+}
+
+// DiskBlobCache is a BlobCache that stores blobs as files in a directory,
+// so it can be shared between processes and survives restarts. Errors
+// writing to the directory are ignored.
+type DiskBlobCache struct {
+	dir string
+}
+
+// NewDiskBlobCache returns a DiskBlobCache storing blobs in dir, which is
+// created if it does not exist.
+func NewDiskBlobCache(dir string) (*DiskBlobCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskBlobCache{dir: dir}, nil
+}
+
+func (c *DiskBlobCache) path(sha string) string {
+	if len(sha) < 3 {
+		return filepath.Join(c.dir, sha)
+	}
+	return filepath.Join(c.dir, sha[:2], sha[2:])
+}
+
+func (c *DiskBlobCache) Get(sha string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(sha))
+	if err != nil {
+		return nil, false
+	}
+	if blobSHA(data) != sha {
+		// partially written or corrupted
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *DiskBlobCache) Add(sha string, data []byte) {
+	p := c.path(sha)
+	if _, err := os.Stat(p); err == nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
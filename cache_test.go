@@ -0,0 +1,68 @@
+package githubfs_test
+
+import (
+	"testing"
+
+	githubfs "github.com/progrium/go-githubfs"
+)
+
+func TestLRUBlobCache(t *testing.T) {
+	c := githubfs.NewLRUBlobCache(10)
+	c.Add("a", []byte("aaaa"))
+	c.Add("b", []byte("bbbb"))
+	if data, ok := c.Get("a"); !ok || string(data) != "aaaa" {
+		t.Fatalf("get: %q %v", data, ok)
+	}
+	// b is now the least recently used
+	c.Add("c", []byte("cccc"))
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used blob wasn't evicted")
+	}
+	for _, sha := range []string{"a", "c"} {
+		if _, ok := c.Get(sha); !ok {
+			t.Fatalf("%s was evicted", sha)
+		}
+	}
+	c.Add("d", []byte("too big to cache"))
+	if _, ok := c.Get("d"); ok {
+		t.Fatal("blob larger than the cache was cached")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("blob larger than the cache evicted others")
+	}
+}
+
+func TestBlobCache(t *testing.T) {
+	dir := t.TempDir()
+	caches := map[string]func() githubfs.BlobCache{
+		"lru": func() githubfs.BlobCache { return githubfs.NewLRUBlobCache(1 << 20) },
+		"disk": func() githubfs.BlobCache {
+			c, err := githubfs.NewDiskBlobCache(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c
+		},
+	}
+	for name, newCache := range caches {
+		srv, fs := newTestFs(t, githubfs.WithBlobCache(newCache()))
+		readFile(t, fs, "a.txt")
+		n := srv.Requests()
+		if got := readFile(t, fs, "a.txt"); got != "hello" {
+			t.Fatalf("%s: read %q", name, got)
+		}
+		if srv.Requests() != n {
+			t.Fatalf("%s: cached blob made %d requests", name, srv.Requests()-n)
+		}
+	}
+
+	// blobs on disk outlive the mount that fetched them
+	srv, fs := newTestFs(t, githubfs.WithBlobCache(caches["disk"]()))
+	n := srv.Requests()
+	if got := readFile(t, fs, "a.txt"); got != "hello" {
+		t.Fatalf("read %q", got)
+	}
+	if srv.Requests() != n {
+		t.Fatalf("blob cached on disk made %d requests", srv.Requests()-n)
+	}
+}
@@ -0,0 +1,113 @@
+package githubfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/afero"
+)
+
+// CommitOptions configures the commits made by the filesystem.
+type CommitOptions struct {
+	// Message is a text/template for the commit message. It is executed
+	// with a CommitInfo and has a join function for formatting paths, e.g.
+	// `{{.Op}} {{join .Paths ", "}}`. Defaults to CommitMessage. New and
+	// WithCommitOptions fail if it isn't a valid template.
+	Message string
+
+	// Author and Committer identify who made the commit. If nil, GitHub
+	// uses the authenticated user.
+	Author    *github.CommitAuthor
+	Committer *github.CommitAuthor
+
+	// tmpl is Message parsed by compile.
+	tmpl *template.Template
+}
+
+// CommitInfo describes the change being committed.
+type CommitInfo struct {
+	// Op is the kind of operation: create, write, mkdir, chmod, symlink,
+	// rename, remove or transaction.
+	Op string
+
+	// Paths are the paths touched by the operation.
+	Paths []string
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// compile parses Message, so a bad template fails when it is configured
+// rather than on the first commit.
+func (o *CommitOptions) compile() error {
+	o.tmpl = nil
+	if o.Message == "" {
+		return nil
+	}
+	t, err := template.New("message").Funcs(templateFuncs).Parse(o.Message)
+	if err != nil {
+		return err
+	}
+	// executing it once catches fields CommitInfo doesn't have
+	if err := t.Execute(ioutil.Discard, CommitInfo{Op: "write", Paths: []string{"file"}}); err != nil {
+		return err
+	}
+	o.tmpl = t
+	return nil
+}
+
+func (o CommitOptions) message(info CommitInfo) (string, error) {
+	if o.tmpl == nil {
+		return CommitMessage, nil
+	}
+	var buf bytes.Buffer
+	if err := o.tmpl.Execute(&buf, info); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// merge returns o with the fields set in override replaced.
+func (o CommitOptions) merge(override CommitOptions) CommitOptions {
+	if override.Message != "" {
+		o.Message = override.Message
+	}
+	if override.Author != nil {
+		o.Author = override.Author
+	}
+	if override.Committer != nil {
+		o.Committer = override.Committer
+	}
+	return o
+}
+
+// WithCommitOptions returns a view of fs whose commits use opts. Fields left
+// empty in opts fall back to the options fs was created with. The view
+// shares its tree and branch with fs. It fails if opts.Message is not a
+// valid template.
+func WithCommitOptions(fs afero.Fs, opts CommitOptions) (afero.Fs, error) {
+	gfs, ok := asGitHubFs(fs)
+	if !ok {
+		return nil, ErrNotGitHubFs
+	}
+	view := *gfs
+	view.commitOpts = gfs.commitOpts.merge(opts)
+	if err := view.commitOpts.compile(); err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+func asGitHubFs(fs afero.Fs) (*githubFs, bool) {
+	switch fs := fs.(type) {
+	case *githubFs:
+		return fs, true
+	case *Tx:
+		return fs.fs, true
+	}
+	return nil, false
+}
@@ -0,0 +1,104 @@
+package githubfs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-github/github"
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/spf13/afero"
+)
+
+func TestCommitMessage(t *testing.T) {
+	// with a placeholder, Mkdir commits too
+	srv, fs := newTestFs(t, githubfs.WithCommitMessage(`{{.Op}} {{join .Paths ", "}}`), githubfs.WithPlaceholder(".gitkeep"))
+	steps := []struct {
+		run  func() error
+		want string
+	}{
+		{func() error { _, err := fs.Create("new.txt"); return err }, "create new.txt"},
+		{func() error { return afero.WriteFile(fs, "new.txt", []byte("new"), 0644) }, "write new.txt"},
+		{func() error { return fs.Mkdir("d", 0755) }, "mkdir d"},
+		{func() error { return fs.Chmod("new.txt", 0755) }, "chmod new.txt"},
+		{func() error { return fs.Rename("new.txt", "renamed.txt") }, "rename new.txt, renamed.txt"},
+		{func() error { return fs.Remove("renamed.txt") }, "remove renamed.txt"},
+	}
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			t.Fatal(err)
+		}
+		if msg := srv.Message("o", "r", srv.Head("o", "r", "master")); msg != s.want {
+			t.Fatalf("commit message %q, want %q", msg, s.want)
+		}
+	}
+}
+
+func TestCommitMessageInvalid(t *testing.T) {
+	srv, fs := newTestFs(t)
+	for _, tmpl := range []string{"{{.Nope", "{{.Nope}}"} {
+		if _, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithCommitMessage(tmpl)); err == nil {
+			t.Fatalf("New accepted %q", tmpl)
+		}
+		if _, err := githubfs.WithCommitOptions(fs, githubfs.CommitOptions{Message: tmpl}); err == nil {
+			t.Fatalf("WithCommitOptions accepted %q", tmpl)
+		}
+	}
+}
+
+func TestCommitAuthor(t *testing.T) {
+	srv, fs := newTestFs(t,
+		githubfs.WithAuthor("Author", "author@example.com"),
+		githubfs.WithCommitter("Committer", "committer@example.com"))
+	if err := afero.WriteFile(fs, "new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := srv.Client().Git.GetCommit(context.Background(), "o", "r", srv.Head("o", "r", "master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetAuthor().GetName() != "Author" || c.GetAuthor().GetEmail() != "author@example.com" {
+		t.Fatalf("author %+v", c.GetAuthor())
+	}
+	if c.GetCommitter().GetName() != "Committer" || c.GetCommitter().GetEmail() != "committer@example.com" {
+		t.Fatalf("committer %+v", c.GetCommitter())
+	}
+}
+
+func TestWithCommitOptions(t *testing.T) {
+	srv, fs := newTestFs(t,
+		githubfs.WithCommitMessage("{{.Op}}"),
+		githubfs.WithAuthor("Author", "author@example.com"))
+	view, err := githubfs.WithCommitOptions(fs, githubfs.CommitOptions{
+		Message: "view {{.Op}}",
+		Author:  &github.CommitAuthor{Name: github.String("Other"), Email: github.String("other@example.com")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(view, "view.txt", []byte("v"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	head := srv.Head("o", "r", "master")
+	c, _, err := srv.Client().Git.GetCommit(context.Background(), "o", "r", head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetMessage() != "view write" || c.GetAuthor().GetName() != "Other" {
+		t.Fatalf("view commit %q by %s", c.GetMessage(), c.GetAuthor().GetName())
+	}
+
+	// the view shares the tree, and fs keeps its own options
+	if _, err := fs.Stat("view.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "view.txt", []byte("fs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err = srv.Client().Git.GetCommit(context.Background(), "o", "r", srv.Head("o", "r", "master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetMessage() != "write" || c.GetAuthor().GetName() != "Author" {
+		t.Fatalf("commit %q by %s", c.GetMessage(), c.GetAuthor().GetName())
+	}
+}
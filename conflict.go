@@ -0,0 +1,266 @@
+package githubfs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/afero"
+)
+
+// ConflictPolicy decides what happens when a commit finds that the branch
+// has moved since the filesystem last synced with it.
+type ConflictPolicy int
+
+const (
+	// ConflictFail fails the commit.
+	ConflictFail ConflictPolicy = iota
+
+	// ConflictRebase replays the pending changes on top of the new branch
+	// head when they touch paths disjoint from the remote changes, and
+	// retries the ref update with backoff. Paths changed on both sides
+	// fail the commit with a *ConflictError listing them.
+	ConflictRebase
+)
+
+const (
+	maxCommitRetries = 5
+	commitBackoff    = 100 * time.Millisecond
+)
+
+var errNotFastForward = errors.New("branch update is not a fast forward")
+
+// ErrBranchMoved reports that the branch was updated by someone else since
+// the filesystem last synced with it. Errors returned for this condition
+// are *ConflictError values, which match ErrBranchMoved with errors.Is.
+var ErrBranchMoved = errors.New("commits have been made since last filesystem operation")
+
+// ConflictError is returned when a commit finds that the branch has moved
+// and the pending changes could not be published on top of it. Like any
+// change that fails to commit, the change is undone, but the filesystem
+// still expects the old head, so every later commit fails the same way
+// until it catches up with Reload.
+type ConflictError struct {
+	// Expected is the commit SHA the filesystem expected the branch at.
+	Expected string
+
+	// Actual is the commit SHA the branch is actually at.
+	Actual string
+
+	// Remote are the paths changed remotely since Expected. Directories
+	// that were never loaded by a lazily loaded filesystem are listed as a
+	// whole.
+	Remote []string
+
+	// Paths are the paths changed both locally and remotely. It is only
+	// set when a rebase was attempted.
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.Paths) > 0 {
+		return "conflicting changes to " + strings.Join(e.Paths, ", ")
+	}
+	return fmt.Sprintf("%s: expected %s, branch is at %s", ErrBranchMoved, e.Expected, e.Actual)
+}
+
+// Is reports whether target is ErrBranchMoved.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrBranchMoved
+}
+
+// Reload fetches the branch fs is on and its tree again, dropping what
+// hasn't been published, such as empty directories without a placeholder.
+// It is how a filesystem catches up after a *ConflictError. A filesystem
+// mounted with WithRef is reloaded at the same commit. It fails with
+// ErrTxInProgress while a transaction is open.
+func Reload(fs afero.Fs) error {
+	gfs, ok := asGitHubFs(fs)
+	if !ok {
+		return ErrNotGitHubFs
+	}
+	gfs.mu.Lock()
+	defer gfs.mu.Unlock()
+	if gfs.tx != nil {
+		return ErrTxInProgress
+	}
+	if gfs.branch.GetName() != "" {
+		if err := gfs.updateBranch(); err != nil {
+			return err
+		}
+	}
+	if gfs.lazy {
+		// directories made locally are gone
+		gfs.loaded = nil
+	}
+	if err := gfs.updateTree(gfs.branch.GetCommit().GetCommit().GetTree().GetSHA()); err != nil {
+		return err
+	}
+	gfs.resetBase()
+	return nil
+}
+
+// conflictError describes the branch having moved to branch.
+func (fs *githubFs) conflictError(branch *github.Branch) error {
+	remote, err := fs.view(branch.GetCommit().GetCommit().GetTree().GetSHA())
+	if err != nil {
+		return err
+	}
+	return &ConflictError{
+		Expected: fs.branch.GetCommit().GetSHA(),
+		Actual:   branch.GetCommit().GetSHA(),
+		Remote:   sortedPaths(fs.changedEntries(fs.base.Entries, remote.Entries)),
+	}
+}
+
+func sortedPaths(m map[string]*github.TreeEntry) []string {
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// rebase replays the changes between fs.base and fs.tree on top of the tree
+// of branch.
+func (fs *githubFs) rebase(branch *github.Branch) error {
+	remote, err := fs.view(branch.GetCommit().GetCommit().GetTree().GetSHA())
+	if err != nil {
+		return err
+	}
+	local := fs.changedEntries(fs.base.Entries, fs.tree.Entries)
+	remoteChanges := fs.changedEntries(fs.base.Entries, remote.Entries)
+	var conflicts []string
+	for p, e := range local {
+		if r, ok := remoteChanges[p]; ok && !sameEntry(e, r) {
+			conflicts = append(conflicts, p)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return &ConflictError{
+			Expected: fs.branch.GetCommit().GetSHA(),
+			Actual:   branch.GetCommit().GetSHA(),
+			Remote:   sortedPaths(remoteChanges),
+			Paths:    conflicts,
+		}
+	}
+
+	oldTree, oldBase := fs.tree, fs.base
+	tree := *remote
+	tree.Entries = append([]github.TreeEntry(nil), remote.Entries...)
+	fs.tree = &tree
+	dirs := make(map[string]bool)
+	for p, e := range local {
+		fs.setEntry(p, e)
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	if err := fs.rebuildTrees(dirs); err != nil {
+		fs.tree, fs.base = oldTree, oldBase
+		return err
+	}
+	fs.base = remote
+	return nil
+}
+
+// setEntry replaces the entry at p with e, adding it if there is none and
+// removing it if e is nil.
+func (fs *githubFs) setEntry(p string, e *github.TreeEntry) {
+	idx := fs.indexed()
+	if i, ok := idx.pos(p); ok {
+		if e == nil {
+			fs.tree.Entries = append(fs.tree.Entries[:i], fs.tree.Entries[i+1:]...)
+			fs.index = nil
+		} else {
+			fs.tree.Entries[i] = *e
+		}
+		return
+	}
+	if e != nil {
+		idx.append(*e)
+	}
+}
+
+// rebuildTrees creates new tree objects for the given directories, deepest
+// first, and drops the ones left empty. Placeholders no longer needed are
+// dropped too.
+func (fs *githubFs) rebuildTrees(dirs map[string]bool) error {
+	var paths []string
+	for d := range dirs {
+		paths = append(paths, d)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Count(paths[i], "/") > strings.Count(paths[j], "/")
+	})
+	for _, d := range paths {
+		fs.dropPlaceholder(d)
+		var children []github.TreeEntry
+		for _, e := range fs.indexed().list(d) {
+			if e.SHA != nil {
+				e.Path = String(path.Base(e.GetPath()))
+				children = append(children, e)
+			}
+		}
+		if len(children) == 0 {
+			fs.setEntry(d, nil)
+			continue
+		}
+		tree, _, err := fs.client.Git.CreateTree(fs.ctx, fs.user, fs.repo, "", children)
+		if err != nil {
+			return err
+		}
+		fs.setEntry(d, &github.TreeEntry{
+			Type: String("tree"),
+			Mode: String("040000"),
+			Path: String(d),
+			SHA:  tree.SHA,
+		})
+	}
+	return nil
+}
+
+// leafEntries indexes the entries that are not loaded directories by
+// path. Directories are compared through their contents instead.
+func (fs *githubFs) leafEntries(entries []github.TreeEntry) map[string]github.TreeEntry {
+	m := make(map[string]github.TreeEntry)
+	for _, e := range entries {
+		if e.GetType() == "tree" && (!fs.lazy || fs.loaded[e.GetPath()]) {
+			continue
+		}
+		m[e.GetPath()] = e
+	}
+	return m
+}
+
+// changedEntries returns the leaf entries that differ between from and to,
+// keyed by path. Entries removed in to map to nil.
+func (fs *githubFs) changedEntries(from, to []github.TreeEntry) map[string]*github.TreeEntry {
+	a, b := fs.leafEntries(from), fs.leafEntries(to)
+	changes := make(map[string]*github.TreeEntry)
+	for p := range a {
+		if _, ok := b[p]; !ok {
+			changes[p] = nil
+		}
+	}
+	for p, e := range b {
+		if old, ok := a[p]; !ok || !sameEntry(&old, &e) {
+			e := e
+			changes[p] = &e
+		}
+	}
+	return changes
+}
+
+func sameEntry(a, b *github.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.GetSHA() == b.GetSHA() && a.GetMode() == b.GetMode()
+}
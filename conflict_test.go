@@ -0,0 +1,176 @@
+package githubfs_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/spf13/afero"
+)
+
+func TestConflict(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		srv, fs := newTestFs(t, opts...)
+		expected := srv.Head("o", "r", "master")
+		actual := srv.Commit("o", "r", "master", map[string]string{"remote.txt": "r"})
+		err := afero.WriteFile(fs, "local.txt", []byte("l"), 0644)
+		var ce *githubfs.ConflictError
+		if !errors.Is(err, githubfs.ErrBranchMoved) || !errors.As(err, &ce) {
+			t.Fatalf("write: %v", err)
+		}
+		if ce.Expected != expected || ce.Actual != actual {
+			t.Fatalf("conflict from %s to %s", ce.Expected, ce.Actual)
+		}
+		if len(ce.Remote) != 1 || ce.Remote[0] != "remote.txt" {
+			t.Fatalf("remote changes %v", ce.Remote)
+		}
+		if srv.Head("o", "r", "master") != actual {
+			t.Fatal("branch was updated")
+		}
+		// the change is undone, and later ones fail too until it is reloaded
+		if _, err := fs.Stat("local.txt"); !os.IsNotExist(err) {
+			t.Fatalf("stat of the failed write: %v", err)
+		}
+		if err := afero.WriteFile(fs, "other.txt", []byte("o"), 0644); !errors.Is(err, githubfs.ErrBranchMoved) {
+			t.Fatalf("second write: %v", err)
+		}
+	}
+}
+
+func TestConflictRebase(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		opts = append(opts, githubfs.WithConflictPolicy(githubfs.ConflictRebase))
+		srv, fs := newTestFs(t, opts...)
+		if got := readFile(t, fs, "dir/sub/c.txt"); got != "see" {
+			t.Fatalf("read %q", got)
+		}
+		srv.Commit("o", "r", "master", map[string]string{"dir/remote.txt": "r", "dir/sub/c.txt": "changed"})
+		if err := afero.WriteFile(fs, "dir/b.txt", []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files := headFiles(srv)
+		if files["dir/b.txt"] != "mine" || files["dir/remote.txt"] != "r" || files["dir/sub/c.txt"] != "changed" {
+			t.Fatalf("unexpected files %v", files)
+		}
+		if got := readFile(t, fs, "dir/remote.txt"); got != "r" {
+			t.Fatalf("read %q after rebase", got)
+		}
+
+		srv.Commit("o", "r", "master", map[string]string{"dir/b.txt": "theirs"})
+		err := afero.WriteFile(fs, "dir/b.txt", []byte("mine again"), 0644)
+		var ce *githubfs.ConflictError
+		if !errors.As(err, &ce) || len(ce.Paths) != 1 || ce.Paths[0] != "dir/b.txt" {
+			t.Fatalf("conflicting write: %v", err)
+		}
+		if headFiles(srv)["dir/b.txt"] != "theirs" {
+			t.Fatal("conflicting change was committed")
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		srv, fs := newTestFs(t, opts...)
+		srv.Commit("o", "r", "master", map[string]string{"remote.txt": "r"})
+		if err := afero.WriteFile(fs, "local.txt", []byte("l"), 0644); !errors.Is(err, githubfs.ErrBranchMoved) {
+			t.Fatalf("write: %v", err)
+		}
+		if err := githubfs.Reload(fs); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Stat("local.txt"); err == nil {
+			t.Fatal("pending change survived the reload")
+		}
+		if got := readFile(t, fs, "remote.txt"); got != "r" {
+			t.Fatalf("read %q after reload", got)
+		}
+		if err := afero.WriteFile(fs, "dir/after.txt", []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files := headFiles(srv)
+		if len(files) != 5 || files["dir/after.txt"] != "a" || files["remote.txt"] != "r" {
+			t.Fatalf("unexpected files %v", files)
+		}
+
+		tx, err := githubfs.Begin(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := githubfs.Reload(fs); err != githubfs.ErrTxInProgress {
+			t.Fatalf("reload in transaction: %v", err)
+		}
+		tx.Rollback()
+	}
+}
+
+// listFiles describes every path of fs by its mode, and its contents if it
+// is a regular file.
+func listFiles(t *testing.T, fs afero.Fs) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := afero.Walk(fs, "/", func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files[p] = fi.Mode().String()
+		if fi.Mode().IsRegular() {
+			files[p] += " " + readFile(t, fs, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestFailedCommit(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		// directories are only committed with a placeholder
+		opts = append(opts, githubfs.WithPlaceholder(".gitkeep"))
+		srv, fs := newTestFs(t, opts...)
+		before := listFiles(t, fs)
+		head := srv.Commit("o", "r", "master", map[string]string{"remote.txt": "r"})
+		ops := map[string]func() error{
+			"create": func() error {
+				_, err := fs.Create("new.txt")
+				return err
+			},
+			"write": func() error {
+				f, err := fs.OpenFile("dir/b.txt", os.O_RDWR, 0644)
+				if err != nil {
+					return err
+				}
+				if _, err := f.Write([]byte("changed")); err != nil {
+					return err
+				}
+				return f.Close()
+			},
+			"mkdir":      func() error { return fs.Mkdir("dir/new", 0755) },
+			"mkdir all":  func() error { return fs.MkdirAll("x/y", 0755) },
+			"rename":     func() error { return fs.Rename("dir/sub", "moved") },
+			"remove":     func() error { return fs.Remove("dir/sub/c.txt") },
+			"remove all": func() error { return fs.RemoveAll("dir") },
+			"remove root": func() error {
+				return fs.RemoveAll("/")
+			},
+			"chmod": func() error { return fs.Chmod("a.txt", 0755) },
+			"symlink": func() error {
+				return fs.(afero.Symlinker).SymlinkIfPossible("a.txt", "dir/link")
+			},
+		}
+		for name, op := range ops {
+			if err := op(); !errors.Is(err, githubfs.ErrBranchMoved) {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if after := listFiles(t, fs); !reflect.DeepEqual(after, before) {
+				t.Fatalf("%s left %v, want %v", name, after, before)
+			}
+		}
+		if srv.Head("o", "r", "master") != head {
+			t.Fatal("branch was updated")
+		}
+	}
+}
@@ -24,7 +24,7 @@ func main() {
 		panic(err)
 	}
 
-	f, err := fs.OpenFile("test/baz2", os.O_APPEND, 0644)
+	f, err := fs.OpenFile("test/baz2", os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
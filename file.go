@@ -16,9 +16,11 @@ package githubfs
 
 import (
 	"bytes"
-	"context"
+	"crypto/sha1"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -42,10 +44,20 @@ type File struct {
 
 	fs    *githubFs
 	entry github.TreeEntry
+
+	// tx is the transaction the handle was opened in, if any.
+	tx *Tx
 }
 
+// NewFileHandle returns a handle for data. Handles of a read-only
+// filesystem are read only.
 func NewFileHandle(data *FileData, fs *githubFs, entry github.TreeEntry) *File {
-	return &File{fileData: data, fs: fs, entry: entry}
+	f := &File{fileData: data, fs: fs, entry: entry}
+	if fs != nil {
+		f.readOnly = fs.readOnly
+		f.tx = fs.tx
+	}
+	return f
 }
 
 func NewReadOnlyFileHandle(data *FileData) *File {
@@ -62,8 +74,24 @@ type FileData struct {
 	data    []byte
 	memDir  Dir
 	dir     bool
+	dirty   bool
 	mode    os.FileMode
 	modtime time.Time
+
+	// size is the size of data while it hasn't been fetched yet.
+	size  int64
+	fetch func() ([]byte, error)
+
+	// stream reads the contents while they haven't been fetched, so
+	// large files are read without holding them in memory. Writing
+	// fetches them.
+	stream *blobReader
+
+	// info is returned by FileInfo.Sys.
+	info *EntryInfo
+
+	// lastCommit looks up the modification time, see ModTimeHistory.
+	lastCommit func() (pathCommit, error)
 }
 
 func (d *FileData) Name() string {
@@ -100,6 +128,60 @@ func SetModTime(f *FileData, mtime time.Time) {
 
 func setModTime(f *FileData, mtime time.Time) {
 	f.modtime = mtime
+	f.lastCommit = nil
+}
+
+// resolve looks up the last commit of f, if its modification time comes
+// from history. On errors the time of the head commit is kept. It must be
+// called with f locked.
+func (f *FileData) resolve() {
+	if f.lastCommit == nil {
+		return
+	}
+	c, err := f.lastCommit()
+	f.lastCommit = nil
+	if err == nil {
+		f.modtime = c.time
+		if f.info != nil {
+			f.info.LastCommit = c.sha
+		}
+	}
+}
+
+// blobSHA returns the git object SHA of a blob with the given contents.
+func blobSHA(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// load fetches the contents of a file opened without them. It must be
+// called with d locked.
+func (d *FileData) load() error {
+	if d.fetch == nil {
+		return nil
+	}
+	data, err := d.fetch()
+	if err != nil {
+		return err
+	}
+	d.data = data
+	d.fetch = nil
+	if d.stream != nil {
+		d.stream.Close()
+		d.stream = nil
+	}
+	return nil
+}
+
+// length returns the size of the contents, fetched or not. It must be
+// called with d locked.
+func (d *FileData) length() int64 {
+	if d.fetch != nil || d.data == nil {
+		return d.size
+	}
+	return int64(len(d.data))
 }
 
 func GetFileInfo(f *FileData) *FileInfo {
@@ -118,10 +200,16 @@ func (f *File) Open() error {
 func (f *File) Close() error {
 	f.fileData.Lock()
 	f.closed = true
+	if f.fileData.stream != nil {
+		f.fileData.stream.Close()
+	}
 	if !f.readOnly {
 		setModTime(f.fileData, time.Now())
 	}
 	f.fileData.Unlock()
+	if f.readOnly {
+		return nil
+	}
 	return f.Sync() // TODO: is this necessary?
 }
 
@@ -134,32 +222,95 @@ func (f *File) Stat() (os.FileInfo, error) {
 }
 
 func (f *File) Sync() error {
-	if f.entry.GetType() == "tree" {
+	if f.entry.GetType() == "tree" || f.readOnly {
 		return nil
 	}
+	rolledBack := f.rolledBack()
 	f.fileData.Lock()
-	blob, _, err := f.fs.client.Git.CreateBlob(context.TODO(), f.fs.user, f.fs.repo, &github.Blob{
+	if !f.fileData.dirty || blobSHA(f.fileData.data) == f.entry.GetSHA() {
+		// nothing to commit
+		f.fileData.dirty = false
+		f.fileData.Unlock()
+		return nil
+	}
+	if rolledBack {
+		f.fileData.Unlock()
+		return ErrTxDone
+	}
+	blob, _, err := f.fs.client.Git.CreateBlob(f.fs.ctx, f.fs.user, f.fs.repo, &github.Blob{
 		Content:  String(base64.StdEncoding.EncodeToString(f.fileData.data)),
 		Encoding: String("base64"),
 	})
+	size := len(f.fileData.data)
+	if err == nil {
+		f.fileData.dirty = false
+		if f.fs.cache != nil {
+			f.fs.cache.Add(blob.GetSHA(), f.fileData.data)
+		}
+	}
 	f.fileData.Unlock()
 	if err != nil {
 		return err
 	}
 	f.fs.mu.Lock()
+	err = f.commit(blob.SHA, size)
+	f.fs.mu.Unlock()
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	if err != nil {
+		// the contents stay unsynced, so a later Sync tries again
+		f.fileData.dirty = true
+		return err
+	}
+	if f.fileData.info != nil {
+		f.fileData.info.SHA = blob.GetSHA()
+		f.fileData.info.Size = int64(size)
+		f.fileData.info.LastCommit = ""
+	}
+	return nil
+}
+
+// rolledBack reports whether f was opened in a transaction that has been
+// rolled back since.
+func (f *File) rolledBack() bool {
+	if f.tx == nil {
+		return false
+	}
+	f.fs.mu.Lock()
 	defer f.fs.mu.Unlock()
-	for i, e := range f.fs.tree.Entries {
-		if e.GetPath() == f.entry.GetPath() {
-			f.fs.tree.Entries[i].SHA = blob.SHA
-			f.entry.SHA = blob.SHA
-		}
+	return f.tx.rolledBack
+}
+
+// commit points the file's entry at the blob sha of the given size and
+// commits it, undoing the change if that fails. It is called with f.fs.mu
+// held.
+func (f *File) commit(sha *string, size int) error {
+	if f.tx != nil && f.tx.rolledBack {
+		// rolled back while the blob was uploaded
+		return ErrTxDone
+	}
+	if err := f.fs.loadDir(filepath.Dir(f.entry.GetPath())); err != nil {
+		return err
+	}
+	restore, oldSHA := f.fs.snapshot(), f.entry.SHA
+	if i, ok := f.fs.indexed().pos(f.entry.GetPath()); ok {
+		f.fs.tree.Entries[i].SHA = sha
+		f.fs.tree.Entries[i].Size = github.Int(size)
+		f.entry.SHA = sha
 	}
 	if strings.Contains(f.entry.GetPath(), FilePathSeparator) {
 		if err := f.fs.createTreesFromEntries(filepath.Dir(f.entry.GetPath()), true); err != nil {
+			restore()
+			f.entry.SHA = oldSHA
 			return err
 		}
 	}
-	return f.fs.commit()
+	if err := f.fs.commit("write", f.entry.GetPath()); err != nil {
+		restore()
+		f.entry.SHA = oldSHA
+		return err
+	}
+	return nil
 }
 
 func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
@@ -205,6 +356,19 @@ func (f *File) Read(b []byte) (n int, err error) {
 	if f.closed == true {
 		return 0, ErrFileClosed
 	}
+	if f.fileData.fetch != nil && f.fileData.stream != nil {
+		n, err = f.fileData.stream.ReadAt(b, f.at)
+		if err != errNoRanges {
+			atomic.AddInt64(&f.at, int64(n))
+			return n, err
+		}
+		// fetched whole instead
+		f.fileData.stream = nil
+		n, err = 0, nil
+	}
+	if err := f.fileData.load(); err != nil {
+		return 0, err
+	}
 	if len(b) > 0 && int(f.at) == len(f.fileData.data) {
 		return 0, io.EOF
 	}
@@ -228,17 +392,28 @@ func (f *File) Truncate(size int64) error {
 		return ErrFileClosed
 	}
 	if f.readOnly {
-		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: errors.New("file handle is read only")}
+		return &os.PathError{Op: "truncate", Path: f.fileData.name, Err: os.ErrPermission}
 	}
 	if size < 0 {
 		return ErrOutOfRange
 	}
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	if size == 0 {
+		// no need to fetch what is thrown away
+		f.fileData.fetch = nil
+		f.fileData.data = []byte{}
+	}
+	if err := f.fileData.load(); err != nil {
+		return err
+	}
 	if size > int64(len(f.fileData.data)) {
 		diff := size - int64(len(f.fileData.data))
 		f.fileData.data = append(f.fileData.data, bytes.Repeat([]byte{00}, int(diff))...)
 	} else {
 		f.fileData.data = f.fileData.data[0:size]
 	}
+	f.fileData.dirty = true
 	setModTime(f.fileData, time.Now())
 	return nil
 }
@@ -253,19 +428,24 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case 1:
 		atomic.AddInt64(&f.at, int64(offset))
 	case 2:
-		atomic.StoreInt64(&f.at, int64(len(f.fileData.data))+offset)
+		f.fileData.Lock()
+		atomic.StoreInt64(&f.at, f.fileData.length()+offset)
+		f.fileData.Unlock()
 	}
 	return f.at, nil
 }
 
 func (f *File) Write(b []byte) (n int, err error) {
 	if f.readOnly {
-		return 0, &os.PathError{Op: "write", Path: f.fileData.name, Err: errors.New("file handle is read only")}
+		return 0, &os.PathError{Op: "write", Path: f.fileData.name, Err: os.ErrPermission}
 	}
 	n = len(b)
 	cur := atomic.LoadInt64(&f.at)
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
+	if err := f.fileData.load(); err != nil {
+		return 0, err
+	}
 	diff := cur - int64(len(f.fileData.data))
 	var tail []byte
 	if n+int(cur) < len(f.fileData.data) {
@@ -278,6 +458,7 @@ func (f *File) Write(b []byte) (n int, err error) {
 		f.fileData.data = append(f.fileData.data[:cur], b...)
 		f.fileData.data = append(f.fileData.data, tail...)
 	}
+	f.fileData.dirty = true
 	setModTime(f.fileData, time.Now())
 
 	atomic.StoreInt64(&f.at, int64(len(f.fileData.data)))
@@ -316,6 +497,7 @@ func (s *FileInfo) Mode() os.FileMode {
 func (s *FileInfo) ModTime() time.Time {
 	s.Lock()
 	defer s.Unlock()
+	s.resolve()
 	return s.modtime
 }
 func (s *FileInfo) IsDir() bool {
@@ -323,14 +505,23 @@ func (s *FileInfo) IsDir() bool {
 	defer s.Unlock()
 	return s.dir
 }
-func (s *FileInfo) Sys() interface{} { return nil }
+func (s *FileInfo) Sys() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	if s.info == nil {
+		return nil
+	}
+	s.resolve()
+	info := *s.info
+	return &info
+}
 func (s *FileInfo) Size() int64 {
 	if s.IsDir() {
 		return int64(42)
 	}
 	s.Lock()
 	defer s.Unlock()
-	return int64(len(s.data))
+	return s.length()
 }
 
 var (
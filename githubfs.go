@@ -5,11 +5,13 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/go-github/github"
@@ -18,28 +20,169 @@ import (
 
 const CommitMessage = "automatic commit from githubfs 🎆"
 
+// ErrIncompleteTree is returned when committing a tree GitHub could not
+// list completely.
+var ErrIncompleteTree = errors.New("tree listing is incomplete, refusing to commit")
+
 func String(s string) *string {
 	return &s
 }
 
 type githubFs struct {
+	*state
+	ctx        context.Context
+	commitOpts CommitOptions
+}
+
+// state is shared between a filesystem and the views derived from it.
+type state struct {
 	client *github.Client
 	user   string
 	repo   string
 	branch *github.Branch
 	tree   *github.Tree
+	base   *github.Tree
+	tx     *Tx
 	mu     sync.Mutex
+
+	// index and baseIndex index tree and base by path. They are rebuilt
+	// when stale, see indexed.
+	index     *index
+	baseIndex *index
+
+	// incomplete is set when the tree could not be listed completely,
+	// since committing it would delete the missing entries.
+	incomplete bool
+
+	// lazy trees only hold the directories in loaded, which are listed
+	// on first access. Listings are memoized by tree SHA in trees.
+	lazy   bool
+	loaded map[string]bool
+	trees  map[string][]github.TreeEntry
+
+	// history caches the last commits of paths, see ModTimeHistory.
+	modTimes  ModTimePolicy
+	history   map[string]pathCommit
+	historyMu sync.Mutex
+
+	// mounts are the filesystems of submodules, keyed by path and commit.
+	submodules bool
+	mounts     map[string]*githubFs
+
+	// placeholder is the name of the file that keeps directories from
+	// being empty, see Options.Placeholder.
+	placeholder string
+
+	// emptyBlobSHA is the SHA of the empty blob once created. New files
+	// and placeholders start out with it.
+	emptyBlobSHA string
+
+	// blobs at least streamThreshold bytes long are streamed, see
+	// Options.StreamThreshold.
+	streamThreshold int64
+
+	// ranges records whether the server honors range requests.
+	ranges   rangeSupport
+	rangesMu sync.Mutex
+
+	readOnly       bool
+	cache          BlobCache
+	logger         Logger
+	conflictPolicy ConflictPolicy
 }
 
 func NewGitHubFs(client *github.Client, user string, repo string, branch string) (afero.Fs, error) {
+	return New(client, user, repo, WithBranch(branch))
+}
+
+// NewGitHubFsWithOptions is like NewGitHubFs, configured by opts. branch
+// replaces opts.Branch, but if opts.Ref is set the ref is mounted read-only
+// and branch is ignored.
+//
+// Deprecated: use New.
+func NewGitHubFsWithOptions(client *github.Client, user string, repo string, branch string, opts Options) (afero.Fs, error) {
+	return New(client, user, repo, func(o *Options) {
+		*o = opts
+		o.Branch = branch
+	})
+}
+
+// New returns a filesystem backed by the given repository, configured by
+// opts. Unless WithBranch is given, the repository's default branch is
+// used.
+func New(client *github.Client, owner string, repo string, opts ...Option) (afero.Fs, error) {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newGitHubFs(client, owner, repo, o)
+}
+
+func newGitHubFs(client *github.Client, user string, repo string, opts Options) (afero.Fs, error) {
+	if opts.BaseURL != "" {
+		c, err := github.NewEnterpriseClient(opts.BaseURL, opts.BaseURL, opts.HTTPClient)
+		if err != nil {
+			return nil, err
+		}
+		if client != nil {
+			c.UserAgent = client.UserAgent
+		}
+		client = c
+	}
+	if err := opts.Commit.compile(); err != nil {
+		return nil, err
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	fs := &githubFs{
-		client: client,
-		user:   user,
-		repo:   repo,
+		state: &state{
+			client: client,
+			user:   user,
+			repo:   repo,
+
+			lazy:            opts.LazyTrees,
+			trees:           make(map[string][]github.TreeEntry),
+			readOnly:        opts.ReadOnly,
+			cache:           opts.Cache,
+			logger:          opts.Logger,
+			conflictPolicy:  opts.ConflictPolicy,
+			modTimes:        opts.ModTimes,
+			history:         make(map[string]pathCommit),
+			submodules:      opts.Submodules,
+			mounts:          make(map[string]*githubFs),
+			placeholder:     opts.Placeholder,
+			streamThreshold: opts.StreamThreshold,
+		},
+		ctx:        ctx,
+		commitOpts: opts.Commit,
+	}
+	if opts.Ref != "" {
+		head, err := fs.resolveRef(opts.Ref)
+		if err != nil {
+			return nil, err
+		}
+		// a detached head has no branch name and can't be committed to
+		fs.branch = &github.Branch{Commit: head}
+		fs.readOnly = true
+		err = fs.updateTree(head.GetCommit().GetTree().GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		fs.resetBase()
+		return fs, nil
+	}
+	branch := opts.Branch
+	if branch == "" {
+		r, _, err := client.Repositories.Get(fs.ctx, user, repo)
+		if err != nil {
+			return nil, err
+		}
+		branch = r.GetDefaultBranch()
 	}
-	ctx := context.Background()
 	var err error
-	fs.branch, _, err = client.Repositories.GetBranch(ctx, user, repo, branch)
+	fs.branch, _, err = client.Repositories.GetBranch(fs.ctx, user, repo, branch)
 	if err != nil {
 		return nil, err
 	}
@@ -47,24 +190,168 @@ func NewGitHubFs(client *github.Client, user string, repo string, branch string)
 	if err != nil {
 		return nil, err
 	}
+	fs.resetBase()
 	return fs, nil
 }
 
-func (fs *githubFs) updateTree(sha string) (err error) {
-	fs.tree, _, err = fs.client.Git.GetTree(context.TODO(), fs.user, fs.repo, sha, true)
-	return
+// resolveRef resolves a branch, tag or commit SHA to a commit.
+func (fs *githubFs) resolveRef(ref string) (*github.RepositoryCommit, error) {
+	sha := ref
+	name := strings.TrimPrefix(ref, "refs/")
+	if !strings.HasPrefix(name, "heads/") && !strings.HasPrefix(name, "tags/") {
+		if b, _, err := fs.client.Repositories.GetBranch(fs.ctx, fs.user, fs.repo, ref); err == nil {
+			return b.GetCommit(), nil
+		}
+		name = "tags/" + name
+	}
+	r, _, err := fs.client.Git.GetRef(fs.ctx, fs.user, fs.repo, name)
+	if err == nil {
+		sha = r.GetObject().GetSHA()
+		if r.GetObject().GetType() == "tag" {
+			// annotated tag
+			tag, _, err := fs.client.Git.GetTag(fs.ctx, fs.user, fs.repo, sha)
+			if err != nil {
+				return nil, err
+			}
+			sha = tag.GetObject().GetSHA()
+		}
+	} else if e, ok := err.(*github.ErrorResponse); !ok || e.Response.StatusCode != http.StatusNotFound {
+		return nil, err
+	}
+	commit, _, err := fs.client.Git.GetCommit(fs.ctx, fs.user, fs.repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	return &github.RepositoryCommit{SHA: commit.SHA, Commit: commit}, nil
+}
+
+// WithContext returns a view of fs whose GitHub API calls use ctx, so they
+// are canceled when ctx is. Files opened through the view use ctx as well.
+// The view shares its tree and branch with fs.
+func WithContext(fs afero.Fs, ctx context.Context) (afero.Fs, error) {
+	gfs, ok := asGitHubFs(fs)
+	if !ok {
+		return nil, ErrNotGitHubFs
+	}
+	view := *gfs
+	view.ctx = ctx
+	return &view, nil
+}
+
+func (fs *githubFs) updateTree(sha string) error {
+	if fs.lazy {
+		// directories loaded so far stay loaded
+		if fs.loaded == nil {
+			fs.loaded = map[string]bool{"": true}
+		}
+		tree, err := fs.view(sha)
+		if err != nil {
+			return err
+		}
+		fs.tree = tree
+		return nil
+	}
+	tree, complete, err := fs.getTree(sha)
+	if err != nil {
+		return err
+	}
+	fs.tree = tree
+	fs.incomplete = !complete
+	return nil
+}
+
+// getTree returns the recursive listing of a tree and whether it is
+// complete. GitHub truncates recursive listings of large trees, in which
+// case the tree is listed again one directory at a time.
+func (fs *githubFs) getTree(sha string) (*github.Tree, bool, error) {
+	tree, _, err := fs.client.Git.GetTree(fs.ctx, fs.user, fs.repo, sha, true)
+	if err != nil {
+		return nil, false, err
+	}
+	if !tree.GetTruncated() {
+		return tree, true, nil
+	}
+	fs.logf("listing of tree %s is truncated, listing it per directory", sha)
+	entries, complete, err := fs.listTree(sha, "")
+	if err != nil {
+		return nil, false, err
+	}
+	return &github.Tree{SHA: tree.SHA, Entries: entries}, complete, nil
+}
+
+// listTree lists a tree recursively, fetching each directory on its own
+// and prefixing the entry paths with prefix.
+func (fs *githubFs) listTree(sha string, prefix string) ([]github.TreeEntry, bool, error) {
+	tree, _, err := fs.client.Git.GetTree(fs.ctx, fs.user, fs.repo, sha, false)
+	if err != nil {
+		return nil, false, err
+	}
+	complete := !tree.GetTruncated()
+	var entries []github.TreeEntry
+	for _, e := range tree.Entries {
+		e.Path = String(prefix + e.GetPath())
+		entries = append(entries, e)
+		if e.GetType() != "tree" {
+			continue
+		}
+		sub, _, err := fs.client.Git.GetTree(fs.ctx, fs.user, fs.repo, e.GetSHA(), true)
+		if err != nil {
+			return nil, false, err
+		}
+		if !sub.GetTruncated() {
+			for _, c := range sub.Entries {
+				c.Path = String(e.GetPath() + "/" + c.GetPath())
+				entries = append(entries, c)
+			}
+			continue
+		}
+		children, ok, err := fs.listTree(e.GetSHA(), e.GetPath()+"/")
+		if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, children...)
+		complete = complete && ok
+	}
+	return entries, complete, nil
+}
+
+// resetBase records the current tree as the last published one, which
+// pending changes are measured against.
+func (fs *githubFs) resetBase() {
+	fs.base = copyTree(fs.tree)
+}
+
+func copyTree(t *github.Tree) *github.Tree {
+	c := *t
+	c.Entries = append([]github.TreeEntry(nil), t.Entries...)
+	return &c
 }
 
 // Create creates a file in the filesystem, returning the file and an
 // error, if any happens.
+// An existing file is truncated.
 func (fs *githubFs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// create creates a file that is executable if perm has any execute bit set.
+func (fs *githubFs) create(name string, perm os.FileMode) (afero.File, error) {
+	if fs.readOnly {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrPermission}
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	normalName := strings.TrimPrefix(name, "/")
+	normalName, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
 	if normalName == "" {
 		return nil, os.ErrInvalid
 	}
-	e := fs.findEntry(normalName)
+	e, err := fs.lookup(normalName)
+	if err != nil {
+		return nil, err
+	}
 	if e != nil {
 		return nil, afero.ErrFileExists
 	}
@@ -74,64 +361,117 @@ func (fs *githubFs) Create(name string) (afero.File, error) {
 		if parent == nil {
 			return nil, os.ErrNotExist
 		}
+		if parent.GetType() != "tree" {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR}
+		}
 	}
-	blob, _, err := fs.client.Git.CreateBlob(context.TODO(), fs.user, fs.repo, &github.Blob{
-		Content: String(""),
-	})
+	sha, err := fs.emptyBlob()
 	if err != nil {
 		return nil, err
 	}
+	restore := fs.snapshot()
 	entry := github.TreeEntry{
 		Type: String("blob"),
-		Mode: String("100644"),
+		Mode: String(gitMode(perm)),
 		Path: String(normalName),
-		SHA:  blob.SHA,
+		SHA:  String(sha),
+		Size: github.Int(0),
 	}
-	fs.tree.Entries = append(fs.tree.Entries, entry)
+	fs.indexed().append(entry)
 	if parent != nil {
 		err = fs.createTreesFromEntries(parent.GetPath(), false)
 		if err != nil {
+			restore()
 			return nil, err
 		}
 	}
-	err = fs.commit()
+	err = fs.commit("create", normalName)
 	if err != nil {
+		restore()
 		return nil, err
 	}
 
 	// TODO: add necessary references
 	fileData := CreateFile(name)
+	SetMode(fileData, fileMode(entry.GetMode()))
+	fileData.info = &EntryInfo{SHA: entry.GetSHA(), Mode: entry.GetMode(), Type: "blob"}
 	file := NewFileHandle(fileData, fs, entry)
 
 	return file, nil
 }
 
+// snapshot returns a function that puts the in-memory tree, along with the
+// base tree and the loaded directories, back the way they are now, to undo
+// a change that failed to publish. It does nothing if a new tree has been
+// loaded since.
+func (fs *githubFs) snapshot() func() {
+	tree, base := fs.tree, copyTree(fs.base)
+	entries := append([]github.TreeEntry(nil), tree.Entries...)
+	var loaded map[string]bool
+	if fs.loaded != nil {
+		loaded = make(map[string]bool, len(fs.loaded))
+		for d := range fs.loaded {
+			loaded[d] = true
+		}
+	}
+	return func() {
+		if fs.tree != tree {
+			return
+		}
+		fs.tree.Entries = entries
+		fs.base = base
+		fs.loaded = loaded
+		fs.index = nil
+	}
+}
+
+// emptyBlob returns the SHA of the empty blob, creating it the first time.
+func (fs *githubFs) emptyBlob() (string, error) {
+	if fs.emptyBlobSHA == "" {
+		blob, _, err := fs.client.Git.CreateBlob(fs.ctx, fs.user, fs.repo, &github.Blob{
+			Content: String(""),
+		})
+		if err != nil {
+			return "", err
+		}
+		fs.emptyBlobSHA = blob.GetSHA()
+	}
+	return fs.emptyBlobSHA, nil
+}
+
 func (fs *githubFs) createTreesFromEntries(path string, force bool) error {
+	if fs.tx != nil {
+		// trees are rebuilt once, when the transaction is committed
+		for d := path; d != ""; d = parentDir(d) {
+			fs.tx.dirs[d] = true
+		}
+		return nil
+	}
+	if fs.dropPlaceholder(path) {
+		// the existing trees still hold the placeholder
+		force = true
+	}
 	entry := fs.findEntry(path)
 	if entry == nil {
 		return fmt.Errorf("entry not found for path '%s'", path)
 	}
 	if entry.SHA == nil || force {
+		idx := fs.indexed()
 		var children []github.TreeEntry
-		for _, e := range fs.tree.Entries {
-			if strings.HasPrefix(e.GetPath(), path+"/") {
-				relativeName := strings.TrimPrefix(e.GetPath(), path+"/")
-				if !strings.Contains(relativeName, FilePathSeparator) {
-					relativeEntry := e
-					relativeEntry.Path = String(relativeName)
-					children = append(children, relativeEntry)
-				}
+		for _, e := range idx.list(path) {
+			if e.SHA == nil {
+				// empty directory
+				continue
 			}
+			e.Path = String(strings.TrimPrefix(e.GetPath(), path+"/"))
+			children = append(children, e)
 		}
-		tree, _, err := fs.client.Git.CreateTree(context.TODO(), fs.user, fs.repo, "", children)
+		tree, _, err := fs.client.Git.CreateTree(fs.ctx, fs.user, fs.repo, "", children)
 		if err != nil {
 			return err
 		}
-		for i, e := range fs.tree.Entries {
-			if e.GetPath() == entry.GetPath() {
-				fs.tree.Entries[i].SHA = tree.SHA
-			}
-		}
+		i, _ := idx.pos(path)
+		fs.tree.Entries[i].SHA = tree.SHA
 	}
 	parentDir := filepath.Dir(path)
 	if parentDir == "." || parentDir == "" {
@@ -143,91 +483,185 @@ func (fs *githubFs) createTreesFromEntries(path string, force bool) error {
 // Mkdir creates a directory in the filesystem, return an error if any
 // happens.
 func (fs *githubFs) Mkdir(name string, perm os.FileMode) error {
+	if fs.readOnly {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	normalName := strings.TrimPrefix(name, "/")
+	normalName, err := fs.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	restore := fs.snapshot()
+	if err := fs.mkdir(name, normalName); err != nil {
+		restore()
+		return err
+	}
+	if err := fs.commitDirs(normalName); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// mkdir adds the directory normalName, the resolved path of name, to the
+// in-memory tree, along with its placeholder if there is one.
+func (fs *githubFs) mkdir(name string, normalName string) error {
 	if strings.Contains(normalName, FilePathSeparator) {
-		if p := fs.findEntry(filepath.Dir(normalName)); p == nil {
+		p, err := fs.lookup(filepath.Dir(normalName))
+		if err != nil {
+			return err
+		}
+		if p == nil {
 			return afero.ErrFileNotFound // parent path does not exist
 		}
+		if p.GetType() != "tree" {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+	}
+	e, err := fs.lookup(normalName)
+	if err != nil {
+		return err
 	}
-	fs.tree.Entries = append(fs.tree.Entries, github.TreeEntry{
+	if e != nil || normalName == "" {
+		return &os.PathError{Op: "mkdir", Path: name, Err: afero.ErrFileExists}
+	}
+	fs.indexed().append(github.TreeEntry{
 		Type: String("tree"),
 		Mode: String("040000"),
 		Path: String(normalName),
 	})
+	if fs.lazy {
+		fs.loaded[normalName] = true
+	}
+	if fs.placeholder != "" {
+		return fs.addPlaceholder(normalName)
+	}
 	return nil
 }
 
+// commitDirs commits the directories made by mkdir, the last one being
+// the deepest. Without a placeholder they can't be stored and are only
+// kept in memory.
+func (fs *githubFs) commitDirs(dirs ...string) error {
+	if fs.placeholder == "" {
+		return nil
+	}
+	if err := fs.createTreesFromEntries(dirs[len(dirs)-1], true); err != nil {
+		return err
+	}
+	return fs.commit("mkdir", dirs...)
+}
+
 // MkdirAll creates a directory path and all parents that does not exist
 // yet.
 func (fs *githubFs) MkdirAll(path string, perm os.FileMode) error {
-	normalName := strings.TrimPrefix(path, "/")
-	parentNames := strings.Split(filepath.Dir(normalName), FilePathSeparator)
-	if len(parentNames) == 0 {
-		return fs.Mkdir(path, perm)
+	if fs.readOnly {
+		return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrPermission}
 	}
+	if fi, err := fs.Stat(path); err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	normalName, err := fs.resolve(path, false)
+	if err != nil {
+		return err
+	}
+	restore := fs.snapshot()
+	var dirs []string
+	parentNames := strings.Split(filepath.Dir(normalName), FilePathSeparator)
 	for i, _ := range parentNames {
-		fs.mu.Lock()
 		parentPath := strings.Join(parentNames[0:i+1], FilePathSeparator)
-		parent := fs.findEntry(parentPath)
-		fs.mu.Unlock()
+		if parentPath == "." {
+			break
+		}
+		parent, err := fs.lookup(parentPath)
+		if err != nil {
+			restore()
+			return err
+		}
 		if parent == nil {
-			err := fs.Mkdir(parentPath, perm)
-			if err != nil {
+			if err := fs.mkdir(parentPath, parentPath); err != nil {
+				restore()
 				return err
 			}
+			dirs = append(dirs, parentPath)
+		} else if parent.GetType() != "tree" {
+			restore()
+			return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
 		}
 	}
-	return fs.Mkdir(path, perm)
+	if err := fs.mkdir(path, normalName); err != nil {
+		restore()
+		return err
+	}
+	if err := fs.commitDirs(append(dirs, normalName)...); err != nil {
+		restore()
+		return err
+	}
+	return nil
 }
 
 func (fs *githubFs) findEntry(name string) *github.TreeEntry {
-	normalName := strings.TrimPrefix(name, "/")
-	for _, e := range fs.tree.Entries {
-		if e.GetPath() == normalName {
-			return &e
-		}
-	}
-	return nil
+	return fs.indexed().get(strings.TrimPrefix(name, "/"))
 }
 
 func (fs *githubFs) open(name string) (afero.File, *FileData, error) {
-	normalName := strings.TrimPrefix(name, "/")
-	entry := fs.findEntry(name)
+	normalName, err := fs.resolve(name, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	sub, subName, err := fs.submodule(normalName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sub != nil {
+		sub.mu.Lock()
+		defer sub.mu.Unlock()
+		return sub.open(subName)
+	}
+	entry, err := fs.lookup(normalName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if normalName == "" {
+		entry = &github.TreeEntry{Type: String("tree"), Mode: String("040000"), SHA: fs.tree.SHA}
+	}
 	if entry == nil {
 		return nil, nil, afero.ErrFileNotFound
 	}
 	if entry.GetType() == "blob" {
-		// if file
-		fd := CreateFile(name)
-		SetMode(fd, os.FileMode(int(0644)))
-		blob, _, err := fs.client.Git.GetBlob(context.TODO(), fs.user, fs.repo, entry.GetSHA())
-		if err != nil {
-			return nil, nil, err
+		// if file, the contents are fetched on first access
+		fd := fs.entryData(name, *entry)
+		sha := entry.GetSHA()
+		fd.fetch = func() ([]byte, error) {
+			return fs.readBlob(sha)
+		}
+		if fs.streamThreshold > 0 && fd.size >= fs.streamThreshold {
+			// large files are read in ranges until they are written to
+			fd.fetch = func() ([]byte, error) {
+				return fs.readRaw(sha)
+			}
+			fd.stream = &blobReader{fs: fs, sha: sha, size: fd.size}
 		}
-		fd.data, _ = base64.StdEncoding.DecodeString(blob.GetContent())
 		return NewFileHandle(fd, fs, *entry), fd, nil
 	}
 	// else if tree/dir
-	dir := CreateDir(name)
-	if normalName == "" {
-		normalName = "."
+	if err := fs.loadDir(normalName); err != nil {
+		return nil, nil, err
 	}
-	for _, e := range fs.tree.Entries {
-		if path.Dir(e.GetPath()) != normalName {
+	dir := fs.entryData(name, *entry)
+	for _, e := range fs.indexed().list(normalName) {
+		if fs.isPlaceholder(e.GetPath()) {
 			continue
 		}
-		normalName := strings.TrimPrefix(e.GetPath(), path.Dir(e.GetPath())+"/")
 		switch e.GetType() {
-		case "blob":
-			f := CreateFile(normalName)
-			SetMode(f, os.FileMode(int(0644)))
-			AddToMemDir(dir, f)
-		case "tree":
-			d := CreateDir(normalName)
-			SetMode(d, os.FileMode(int(040000)))
-			AddToMemDir(dir, d)
+		case "blob", "tree", "commit":
+			AddToMemDir(dir, fs.entryData(path.Base(e.GetPath()), e))
 		default:
 			continue
 		}
@@ -235,160 +669,485 @@ func (fs *githubFs) open(name string) (afero.File, *FileData, error) {
 	return NewFileHandle(dir, fs, github.TreeEntry{Type: String("tree")}), dir, nil
 }
 
+// entryData describes the tree entry e, without its contents.
+func (fs *githubFs) entryData(name string, e github.TreeEntry) *FileData {
+	var fd *FileData
+	if e.GetType() == "tree" || e.GetType() == "commit" {
+		fd = CreateDir(name)
+	} else {
+		fd = CreateFile(name)
+		fd.size = int64(e.GetSize())
+	}
+	fd.info = &EntryInfo{
+		SHA:  e.GetSHA(),
+		Mode: e.GetMode(),
+		Type: e.GetType(),
+		Size: int64(e.GetSize()),
+	}
+	SetMode(fd, fileMode(e.GetMode()))
+	fs.deriveModTime(fd, e.GetPath())
+	return fd
+}
+
+// fileMode maps a git file mode to an os.FileMode. Submodules are
+// directories with os.ModeIrregular set.
+func fileMode(mode string) os.FileMode {
+	switch mode {
+	case "040000":
+		return os.ModeDir | 0755
+	case "100755":
+		return 0755
+	case "120000":
+		return os.ModeSymlink | 0777
+	case "160000":
+		return os.ModeDir | os.ModeIrregular | 0755
+	default:
+		return 0644
+	}
+}
+
+// gitMode returns the git file mode of a regular file with permissions
+// perm. Git only records whether a file is executable.
+func gitMode(perm os.FileMode) string {
+	if perm&0111 != 0 {
+		return "100755"
+	}
+	return "100644"
+}
+
+// readBlob returns the contents of the blob with the given SHA, from the
+// cache if possible.
+func (fs *githubFs) readBlob(sha string) ([]byte, error) {
+	if fs.cache != nil {
+		if data, ok := fs.cache.Get(sha); ok {
+			return data, nil
+		}
+	}
+	blob, _, err := fs.client.Git.GetBlob(fs.ctx, fs.user, fs.repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(blob.GetContent())
+	if err != nil {
+		return nil, err
+	}
+	if fs.cache != nil {
+		fs.cache.Add(sha, data)
+	}
+	return data, nil
+}
+
 // Open opens a file, returning it or an error, if any happens.
 func (fs *githubFs) Open(name string) (afero.File, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 	f, _, err := fs.open(name)
-	return f, err
+	if err != nil {
+		return nil, err
+	}
+	f.(*File).readOnly = true
+	return f, nil
 }
 
 // OpenFile opens a file using the given flags and the given mode.
 func (fs *githubFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if fs.readOnly && flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
 	fs.mu.Lock()
-	_, fd, err := fs.open(name)
+	f, _, err := fs.open(name)
 	fs.mu.Unlock()
 	if err == afero.ErrFileNotFound && flag&os.O_CREATE != 0 {
-		return fs.Create(name)
-	}
-	entry := fs.findEntry(name)
-	if fd != nil && entry != nil {
-		SetMode(fd, perm)
-		file := NewFileHandle(fd, fs, *entry)
-		if flag&os.O_APPEND > 0 {
-			_, err := file.Seek(0, os.SEEK_END)
-			if err != nil {
-				file.Close()
-				return nil, err
-			}
+		return fs.create(name, perm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: name, Err: afero.ErrFileExists}
+	}
+	file := f.(*File)
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		file.readOnly = true
+	}
+	if flag&os.O_TRUNC > 0 && !file.readOnly {
+		if err := file.Truncate(0); err != nil {
+			return nil, err
+		}
+	}
+	if flag&os.O_APPEND > 0 {
+		_, err := file.Seek(0, os.SEEK_END)
+		if err != nil {
+			file.Close()
+			return nil, err
 		}
-		return file, nil
 	}
-	return nil, err
+	return file, nil
 }
 
-// Remove removes a file identified by name, returning an error, if any
-// happens.
+// Remove removes a file or an empty directory identified by name,
+// returning an error, if any happens.
 func (fs *githubFs) Remove(name string) error {
+	if fs.readOnly {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	return fs.remove(name)
-}
-
-func (fs *githubFs) remove(name string) error {
-	normalName := strings.TrimPrefix(name, "/")
-	entry := fs.findEntry(name)
-	if entry == nil {
-		return afero.ErrFileNotFound
+	normalName, err := fs.resolve(name, false)
+	if err != nil {
+		return err
 	}
-	resp, _, err := fs.client.Repositories.DeleteFile(context.TODO(), fs.user, fs.repo, normalName, &github.RepositoryContentFileOptions{
-		Message: String(CommitMessage),
-		SHA:     String(entry.GetSHA()),
-		Branch:  String(fs.branch.GetName()),
-	})
+	entry, err := fs.lookup(normalName)
 	if err != nil {
 		return err
 	}
-	return fs.updateTree(resp.Tree.GetSHA())
+	if entry == nil || normalName == "" {
+		return &os.PathError{Op: "remove", Path: name, Err: afero.ErrFileNotFound}
+	}
+	if entry.GetType() == "tree" {
+		if err := fs.loadDir(normalName); err != nil {
+			return err
+		}
+		for _, e := range fs.indexed().list(normalName) {
+			if !fs.isPlaceholder(e.GetPath()) {
+				return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+			}
+		}
+	}
+	return fs.removeEntries(normalName)
 }
 
 // RemoveAll removes a directory path and any children it contains. It
-// does not fail if the path does not exist (return nil).
+// does not fail if the path does not exist (return nil). Removing the root
+// empties the repository.
 func (fs *githubFs) RemoveAll(path string) error {
+	if fs.readOnly {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrPermission}
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	normalName := strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")
-	entry := fs.findEntry(normalName)
-	if entry == nil {
-		return afero.ErrFileNotFound
-	}
-	if entry.GetType() == "blob" {
-		return fs.remove(path)
+	normalName, err := fs.resolve(path, false)
+	if err != nil {
+		return err
 	}
-	// TODO: remove all files in a single commit
-	for _, e := range fs.tree.Entries {
-		if e.GetType() == "tree" {
-			continue
+	if normalName == "" {
+		// everything goes, as with MemMapFs
+		restore := fs.snapshot()
+		fs.tree.Entries = nil
+		fs.index = nil
+		if fs.lazy {
+			fs.loaded = map[string]bool{"": true}
 		}
-		if strings.HasPrefix(e.GetPath(), normalName+"/") {
-			err := fs.remove(e.GetPath())
-			if err != nil {
-				return err
-			}
+		if err := fs.commit("remove", "/"); err != nil {
+			restore()
+			return err
 		}
+		return nil
 	}
-	return nil
+	entry, err := fs.lookup(normalName)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return nil
+	}
+	// subtrees that aren't loaded go along with their parent's entry
+	return fs.removeEntries(normalName)
 }
 
-// Rename renames a file.
+// Rename renames a file, or a directory along with everything in it.
 func (fs *githubFs) Rename(oldname, newname string) error {
+	if fs.readOnly {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrPermission}
+	}
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	normalOld := strings.TrimPrefix(oldname, "/")
-	normalNew := strings.TrimPrefix(newname, "/")
+	normalOld, err := fs.resolve(oldname, false)
+	if err != nil {
+		return err
+	}
+	normalNew, err := fs.resolve(newname, false)
+	if err != nil {
+		return err
+	}
+	old, err := fs.lookup(normalOld)
+	if err != nil {
+		return err
+	}
+	if old == nil || normalOld == "" {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: afero.ErrFileNotFound}
+	}
+	if normalNew == normalOld {
+		return nil
+	}
+	if normalNew == "" || strings.HasPrefix(normalNew, normalOld+"/") {
+		// a directory can't be moved into itself
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrInvalid}
+	}
+	e, err := fs.lookup(normalNew)
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: afero.ErrFileExists}
+	}
+	newParent := parentDir(normalNew)
+	if newParent != "" && fs.findEntry(newParent).GetType() != "tree" {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: afero.ErrFileNotFound}
+	}
+	restore := fs.snapshot()
+	// children move along with a directory. In lazy mode the ones that
+	// aren't loaded move with the SHA of their tree.
 	for i, e := range fs.tree.Entries {
-		if e.GetPath() == normalOld {
-			fs.tree.Entries[i].Path = String(normalNew)
+		if e.GetPath() == normalOld || strings.HasPrefix(e.GetPath(), normalOld+"/") {
+			fs.tree.Entries[i].Path = String(normalNew + strings.TrimPrefix(e.GetPath(), normalOld))
+		}
+	}
+	fs.index = nil
+	if fs.lazy {
+		for d := range fs.loaded {
+			if d == normalOld || strings.HasPrefix(d, normalOld+"/") {
+				delete(fs.loaded, d)
+				fs.loaded[normalNew+strings.TrimPrefix(d, normalOld)] = true
+			}
+		}
+	}
+	oldParent, err := fs.emptyDirs(parentDir(normalOld))
+	if err != nil {
+		restore()
+		return err
+	}
+	for _, dir := range []string{oldParent, newParent} {
+		if dir == "" {
+			continue
+		}
+		if err := fs.createTreesFromEntries(dir, true); err != nil {
+			restore()
+			return err
 		}
 	}
-	return fs.commit()
+	if err := fs.commit("rename", normalOld, normalNew); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// emptyDirs marks dir and its parents as empty directories as long as
+// nothing is left in them, and returns the first one that isn't empty.
+// Empty directories are kept in memory, but not committed, unless they
+// get a placeholder.
+func (fs *githubFs) emptyDirs(dir string) (string, error) {
+	idx := fs.indexed()
+	for ; dir != ""; dir = parentDir(dir) {
+		for _, e := range idx.list(dir) {
+			if e.SHA != nil {
+				return dir, nil
+			}
+		}
+		if fs.placeholder != "" {
+			return dir, fs.addPlaceholder(dir)
+		}
+		i, _ := idx.pos(dir)
+		fs.tree.Entries[i].SHA = nil
+	}
+	return "", nil
 }
 
 func (fs *githubFs) updateBranch() (err error) {
-	fs.branch, _, err = fs.client.Repositories.GetBranch(context.TODO(), fs.user, fs.repo, fs.branch.GetName())
+	fs.branch, _, err = fs.client.Repositories.GetBranch(fs.ctx, fs.user, fs.repo, fs.branch.GetName())
 	return
 }
 
-func (fs *githubFs) commit() error {
-	// TODO: can we do this with less requests?
-	branch, _, err := fs.client.Repositories.GetBranch(context.TODO(), fs.user, fs.repo, fs.branch.GetName())
+// removeEntries drops the entry at path and anything below it from the
+// in-memory tree and rebuilds the parent trees.
+func (fs *githubFs) removeEntries(path string) error {
+	restore := fs.snapshot()
+	var entries []github.TreeEntry
+	for _, e := range fs.tree.Entries {
+		if e.GetPath() == path || strings.HasPrefix(e.GetPath(), path+"/") {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	fs.tree.Entries = entries
+	fs.index = nil
+	for d := range fs.loaded {
+		if d == path || strings.HasPrefix(d, path+"/") {
+			delete(fs.loaded, d)
+		}
+	}
+	dir, err := fs.emptyDirs(parentDir(path))
 	if err != nil {
+		restore()
 		return err
 	}
-	// TODO: can we remove this constraint? make it more like user workflow?
-	if branch.GetCommit().GetSHA() != fs.branch.GetCommit().GetSHA() {
-		return errors.New("commits have been made since last filesystem operation")
+	if dir != "" {
+		if err := fs.createTreesFromEntries(dir, true); err != nil {
+			restore()
+			return err
+		}
+	}
+	if err := fs.commit("remove", path); err != nil {
+		restore()
+		return err
 	}
-	fs.branch = branch
+	return nil
+}
 
-	tree, _, err := fs.client.Git.CreateTree(context.TODO(), fs.user, fs.repo, "", fs.tree.Entries)
+// commit publishes the in-memory tree as a new commit on the branch, or
+// stages it if a transaction is in progress. op and paths describe the
+// change for the commit message template. Callers undo their change with a
+// snapshot if it fails.
+func (fs *githubFs) commit(op string, paths ...string) error {
+	if fs.tx != nil {
+		fs.tx.dirty = true
+		fs.tx.paths = append(fs.tx.paths, paths...)
+		return nil
+	}
+	message, err := fs.commitOpts.message(CommitInfo{Op: op, Paths: paths})
 	if err != nil {
 		return err
 	}
-	err = fs.updateTree(tree.GetSHA())
+	return fs.publish(message)
+}
+
+// publish commits the in-memory tree with message. If it fails, a rebase
+// done along the way is undone, so the tree is the one it was called with.
+func (fs *githubFs) publish(message string) error {
+	if fs.incomplete {
+		return ErrIncompleteTree
+	}
+	oldTree, oldBase, oldBranch := fs.tree, fs.base, fs.branch
+	published := false
+	defer func() {
+		if !published {
+			fs.tree, fs.base, fs.branch = oldTree, oldBase, oldBranch
+		}
+	}()
+	for attempt := 0; ; attempt++ {
+		// TODO: can we do this with less requests?
+		branch, _, err := fs.client.Repositories.GetBranch(fs.ctx, fs.user, fs.repo, fs.branch.GetName())
+		if err != nil {
+			return err
+		}
+		if branch.GetCommit().GetSHA() != fs.branch.GetCommit().GetSHA() {
+			if fs.conflictPolicy != ConflictRebase {
+				return fs.conflictError(branch)
+			}
+			fs.logf("branch %s moved to %s, rebasing", fs.branch.GetName(), branch.GetCommit().GetSHA())
+			if err := fs.rebase(branch); err != nil {
+				return err
+			}
+		}
+		fs.branch = branch
+
+		err = fs.pushCommit(message)
+		if err == errNotFastForward {
+			if fs.conflictPolicy == ConflictRebase && attempt < maxCommitRetries {
+				fs.logf("ref update of %s rejected, retrying", fs.branch.GetName())
+				select {
+				case <-time.After(commitBackoff << uint(attempt)):
+					continue
+				case <-fs.ctx.Done():
+					return fs.ctx.Err()
+				}
+			}
+			branch, _, err := fs.client.Repositories.GetBranch(fs.ctx, fs.user, fs.repo, fs.branch.GetName())
+			if err != nil {
+				return err
+			}
+			return fs.conflictError(branch)
+		}
+		if err != nil {
+			return err
+		}
+		published = true
+		fs.resetBase()
+		fs.logf("committed tree %s to %s", fs.tree.GetSHA(), fs.branch.GetName())
+		return fs.updateBranch()
+	}
+}
+
+func (fs *githubFs) logf(format string, v ...interface{}) {
+	if fs.logger != nil {
+		fs.logger.Printf(format, v...)
+	}
+}
+
+func (fs *githubFs) pushCommit(message string) error {
+	var entries []github.TreeEntry
+	for _, e := range fs.tree.Entries {
+		// git can't store empty directories
+		if e.SHA != nil {
+			entries = append(entries, e)
+		}
+	}
+	tree, _, err := fs.client.Git.CreateTree(fs.ctx, fs.user, fs.repo, "", entries)
 	if err != nil {
 		return err
 	}
 
-	commit, _, err := fs.client.Git.CreateCommit(context.TODO(), fs.user, fs.repo, &github.Commit{
-		Message: String(CommitMessage),
-		Tree:    fs.tree,
-		Parents: []github.Commit{{SHA: fs.branch.GetCommit().SHA}},
+	commit, _, err := fs.client.Git.CreateCommit(fs.ctx, fs.user, fs.repo, &github.Commit{
+		Message:   String(message),
+		Tree:      tree,
+		Parents:   []github.Commit{{SHA: fs.branch.GetCommit().SHA}},
+		Author:    fs.commitOpts.Author,
+		Committer: fs.commitOpts.Committer,
 	})
 	if err != nil {
 		return err
 	}
-	_, _, err = fs.client.Git.UpdateRef(context.TODO(), fs.user, fs.repo, &github.Reference{
+	_, _, err = fs.client.Git.UpdateRef(fs.ctx, fs.user, fs.repo, &github.Reference{
 		Ref: String("heads/" + fs.branch.GetName()),
 		Object: &github.GitObject{
 			SHA: commit.SHA,
 		},
 	}, false)
+	if e, ok := err.(*github.ErrorResponse); ok && e.Response.StatusCode == http.StatusUnprocessableEntity &&
+		strings.Contains(strings.ToLower(e.Message), "not a fast forward") {
+		return errNotFastForward
+	}
 	if err != nil {
 		return err
 	}
-	return fs.updateBranch()
+	// the tree is only replaced once published, so a rejected update
+	// can be rebased with the pending changes intact
+	return fs.updateTree(tree.GetSHA())
 }
 
 // Stat returns a FileInfo describing the named file, or an error, if any
-// happens.
+// happens. It only uses the tree and doesn't fetch the file's contents.
 func (fs *githubFs) Stat(name string) (os.FileInfo, error) {
-	// TODO: properly
-	f, err := fs.Open(name)
+	return fs.stat(name, true)
+}
+
+// stat describes name, following a symlink at name if follow is set.
+func (fs *githubFs) stat(name string, follow bool) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	normalName, err := fs.resolve(name, follow)
+	if err != nil {
+		return nil, err
+	}
+	sub, subName, err := fs.submodule(normalName)
+	if err != nil {
+		return nil, err
+	}
+	if sub != nil && subName != "" {
+		return sub.stat(subName, follow)
+	}
+	if normalName == "" {
+		return &FileInfo{fs.entryData(name, github.TreeEntry{Type: String("tree"), Mode: String("040000"), SHA: fs.tree.SHA})}, nil
+	}
+	entry, err := fs.lookup(normalName)
 	if err != nil {
 		return nil, err
 	}
-	return f.Stat()
+	if entry == nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: afero.ErrFileNotFound}
+	}
+	return &FileInfo{fs.entryData(name, *entry)}, nil
 }
 
 // The name of this FileSystem
@@ -396,14 +1155,66 @@ func (fs *githubFs) Name() string {
 	return "github-api"
 }
 
-//Chmod changes the mode of the named file to mode.
+//Chmod changes the mode of the named file to mode. Only the executable
+// bit of regular files is stored, other modes are ignored.
 func (fs *githubFs) Chmod(name string, mode os.FileMode) error {
-	// TODO: NOT YET IMPLEMENTED
+	if fs.readOnly {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrPermission}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	normalName, err := fs.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	entry, err := fs.lookup(normalName)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: afero.ErrFileNotFound}
+	}
+	if entry.GetMode() != "100644" && entry.GetMode() != "100755" {
+		return nil
+	}
+	newMode := gitMode(mode)
+	if entry.GetMode() == newMode {
+		return nil
+	}
+	restore := fs.snapshot()
+	i, _ := fs.indexed().pos(normalName)
+	fs.tree.Entries[i].Mode = String(newMode)
+	if strings.Contains(normalName, FilePathSeparator) {
+		if err := fs.createTreesFromEntries(filepath.Dir(normalName), true); err != nil {
+			restore()
+			return err
+		}
+	}
+	if err := fs.commit("chmod", normalName); err != nil {
+		restore()
+		return err
+	}
 	return nil
 }
 
+// Chown changes the uid and gid of the named file. Git doesn't store
+// owners, so it only checks that the file exists.
+func (fs *githubFs) Chown(name string, uid, gid int) error {
+	if fs.readOnly {
+		return &os.PathError{Op: "chown", Path: name, Err: os.ErrPermission}
+	}
+	_, err := fs.Stat(name)
+	return err
+}
+
 //Chtimes changes the access and modification times of the named file
 func (fs *githubFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	// no-op
+	if fs.readOnly {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrPermission}
+	}
+	if _, err := fs.Stat(name); err != nil {
+		return err
+	}
+	// no-op, git doesn't store modification times, see ModTimePolicy
 	return nil
 }
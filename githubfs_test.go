@@ -0,0 +1,386 @@
+package githubfs_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/progrium/go-githubfs/githubfstest"
+	"github.com/spf13/afero"
+)
+
+// testFiles are the contents of the repository made by newTestFs.
+var testFiles = map[string]string{
+	"a.txt":         "hello",
+	"dir/b.txt":     "bee",
+	"dir/sub/c.txt": "see",
+}
+
+// newTestFs mounts the master branch of a fake repository holding
+// testFiles.
+func newTestFs(t *testing.T, opts ...githubfs.Option) (*githubfstest.Server, afero.Fs) {
+	t.Helper()
+	srv := githubfstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.CreateRepo("o", "r", "master", testFiles)
+	fs, err := githubfs.New(srv.Client(), "o", "r", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, fs
+}
+
+// headFiles returns the files of the head of master.
+func headFiles(srv *githubfstest.Server) map[string]string {
+	return srv.Files("o", "r", srv.Head("o", "r", "master"))
+}
+
+// parentOf returns the first parent of the commit sha.
+func parentOf(t *testing.T, srv *githubfstest.Server, sha string) string {
+	t.Helper()
+	commit, _, err := srv.Client().Git.GetCommit(context.Background(), "o", "r", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commit.Parents) == 0 {
+		return ""
+	}
+	return commit.Parents[0].GetSHA()
+}
+
+func readFile(t *testing.T, fs afero.Fs, name string) string {
+	t.Helper()
+	data, err := afero.ReadFile(fs, name)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestWriteFile(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		srv, fs := newTestFs(t, opts...)
+		if err := afero.WriteFile(fs, "dir/sub/d.txt", []byte("dee"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files := headFiles(srv)
+		if len(files) != 4 || files["dir/sub/d.txt"] != "dee" || files["a.txt"] != "hello" {
+			t.Fatalf("unexpected files %v", files)
+		}
+		if got := readFile(t, fs, "dir/sub/d.txt"); got != "dee" {
+			t.Fatalf("read %q", got)
+		}
+	}
+}
+
+func TestUnchangedClose(t *testing.T) {
+	srv, fs := newTestFs(t)
+	head := srv.Head("o", "r", "master")
+	for _, flag := range []int{os.O_RDONLY, os.O_RDWR} {
+		f, err := fs.OpenFile("dir/b.txt", flag, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flag == os.O_RDWR {
+			// writing the same contents back changes nothing
+			if _, err := f.WriteAt([]byte("bee"), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n := srv.Requests()
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if srv.Requests() != n {
+			t.Fatalf("closing an unchanged file made %d requests", srv.Requests()-n)
+		}
+	}
+	if srv.Head("o", "r", "master") != head {
+		t.Fatal("closing an unchanged file made a commit")
+	}
+}
+
+func TestStat(t *testing.T) {
+	srv, fs := newTestFs(t)
+	n := srv.Requests()
+	sizes := make(map[string]int64)
+	err := afero.Walk(fs, "/", func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			sizes[p[1:]] = fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat("dir/sub"); err != nil || !fi.IsDir() {
+		t.Fatalf("stat: %v %v", fi, err)
+	}
+	if srv.Requests() != n {
+		t.Fatalf("stat made %d requests", srv.Requests()-n)
+	}
+	for name, data := range testFiles {
+		if sizes[name] != int64(len(data)) {
+			t.Fatalf("size of %s is %d", name, sizes[name])
+		}
+	}
+}
+
+func TestLazyTrees(t *testing.T) {
+	srv, fs := newTestFs(t, githubfs.WithLazyTrees())
+	n := srv.Requests()
+	if _, err := fs.Stat("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Requests() != n {
+		t.Fatalf("stat of a root file made %d requests", srv.Requests()-n)
+	}
+	if got := readFile(t, fs, "dir/sub/c.txt"); got != "see" {
+		t.Fatalf("read %q", got)
+	}
+	if srv.Requests() == n {
+		t.Fatal("subdirectories weren't listed on access")
+	}
+	n = srv.Requests()
+	if _, err := fs.Stat("dir/sub/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Requests() != n {
+		t.Fatalf("listed directories were listed again")
+	}
+
+	// writing to one directory keeps the ones that were never listed
+	srv, fs = newTestFs(t, githubfs.WithLazyTrees())
+	if err := afero.WriteFile(fs, "new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := headFiles(srv)
+	if len(files) != 4 || files["dir/sub/c.txt"] != "see" {
+		t.Fatalf("unexpected files %v", files)
+	}
+}
+
+func TestTruncatedTree(t *testing.T) {
+	srv := githubfstest.NewServer()
+	defer srv.Close()
+	srv.MaxTreeEntries = 3
+	srv.CreateRepo("o", "r", "master", map[string]string{
+		"a":   "1",
+		"d/b": "2",
+		"d/c": "3",
+		"e/f": "4",
+	})
+	fs, err := githubfs.New(srv.Client(), "o", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, "e/f"); got != "4" {
+		t.Fatalf("read %q", got)
+	}
+	if err := afero.WriteFile(fs, "e/g", []byte("5"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if files := headFiles(srv); len(files) != 5 || files["d/c"] != "3" {
+		t.Fatalf("unexpected files %v", files)
+	}
+}
+
+func TestRename(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		srv, fs := newTestFs(t, opts...)
+		head := srv.Head("o", "r", "master")
+		if err := fs.Rename("a.txt", "dir/b.txt"); !errors.Is(err, os.ErrExist) {
+			t.Fatalf("rename onto a file: %v", err)
+		}
+		if err := fs.Rename("a.txt", "missing/a.txt"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("rename into a missing directory: %v", err)
+		}
+		if srv.Head("o", "r", "master") != head {
+			t.Fatal("failed rename made a commit")
+		}
+		if err := fs.Rename("dir", "moved"); err != nil {
+			t.Fatal(err)
+		}
+		if parentOf(t, srv, srv.Head("o", "r", "master")) != head {
+			t.Fatal("rename made more than one commit")
+		}
+		files := headFiles(srv)
+		if len(files) != 3 || files["moved/b.txt"] != "bee" || files["moved/sub/c.txt"] != "see" {
+			t.Fatalf("unexpected files %v", files)
+		}
+		if _, err := fs.Stat("dir"); !os.IsNotExist(err) {
+			t.Fatalf("stat of the old name: %v", err)
+		}
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		srv, fs := newTestFs(t, opts...)
+		head := srv.Head("o", "r", "master")
+		if err := fs.RemoveAll("dir"); err != nil {
+			t.Fatal(err)
+		}
+		if parentOf(t, srv, srv.Head("o", "r", "master")) != head {
+			t.Fatal("removal made more than one commit")
+		}
+		if files := headFiles(srv); len(files) != 1 || files["a.txt"] != "hello" {
+			t.Fatalf("unexpected files %v", files)
+		}
+		head = srv.Head("o", "r", "master")
+		if err := fs.RemoveAll("missing"); err != nil {
+			t.Fatal(err)
+		}
+		if srv.Head("o", "r", "master") != head {
+			t.Fatal("removing a missing path made a commit")
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	srv, _ := newTestFs(t)
+	head := srv.Head("o", "r", "master")
+	for _, opts := range [][]githubfs.Option{{githubfs.WithReadOnly()}, {githubfs.WithRef(head)}} {
+		fs, err := githubfs.New(srv.Client(), "o", "r", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := srv.Requests()
+		if _, err := fs.Create("new.txt"); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("create: %v", err)
+		}
+		if _, err := fs.OpenFile("a.txt", os.O_RDWR, 0); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("open for writing: %v", err)
+		}
+		if err := fs.Mkdir("new", 0755); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := fs.Rename("a.txt", "b.txt"); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("rename: %v", err)
+		}
+		if err := fs.RemoveAll("dir"); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("remove: %v", err)
+		}
+		if err := fs.Chmod("a.txt", 0755); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("chmod: %v", err)
+		}
+		if srv.Requests() != n {
+			t.Fatalf("read-only mount made %d requests", srv.Requests()-n)
+		}
+	}
+}
+
+func TestRef(t *testing.T) {
+	srv, _ := newTestFs(t)
+	head := srv.Head("o", "r", "master")
+	srv.Tag("o", "r", "light", head, false)
+	srv.Tag("o", "r", "annotated", head, true)
+	srv.Commit("o", "r", "master", map[string]string{"a.txt": "changed"})
+	for _, ref := range []string{"light", "annotated", head} {
+		fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithRef(ref))
+		if err != nil {
+			t.Fatalf("mounting %s: %v", ref, err)
+		}
+		if got := readFile(t, fs, "a.txt"); got != "hello" {
+			t.Fatalf("read %q at %s", got, ref)
+		}
+	}
+	fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithRef("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, "a.txt"); got != "changed" {
+		t.Fatalf("read %q at master", got)
+	}
+	if _, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithRef("missing")); err == nil {
+		t.Fatal("mounted a missing ref")
+	}
+}
+
+func TestContext(t *testing.T) {
+	srv, _ := newTestFs(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithDefaultContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("mount with a canceled context: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithDefaultContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := afero.WriteFile(fs, "new.txt", []byte("new"), 0644); !errors.Is(err, context.Canceled) {
+		t.Fatalf("write with a canceled context: %v", err)
+	}
+	view, err := githubfs.WithContext(fs, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(view, "new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if headFiles(srv)["new.txt"] != "new" {
+		t.Fatal("write through the view wasn't committed")
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	srv, fs := newTestFs(t)
+	sl := fs.(afero.Symlinker)
+	if err := sl.SymlinkIfPossible("sub/c.txt", "dir/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.SymlinkIfPossible("loop2", "loop1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.SymlinkIfPossible("loop1", "loop2"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := githubfs.New(srv.Client(), "o", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range []afero.Fs{fs, reloaded} {
+		if got := readFile(t, fs, "dir/link"); got != "see" {
+			t.Fatalf("read %q through link", got)
+		}
+		if _, err := fs.Open("loop1"); !errors.Is(err, githubfs.ErrSymlinkLoop) {
+			t.Fatalf("open: %v", err)
+		}
+		if _, err := fs.Stat("loop2/x"); !errors.Is(err, githubfs.ErrSymlinkLoop) {
+			t.Fatalf("stat: %v", err)
+		}
+		if _, _, err := fs.(afero.Symlinker).LstatIfPossible("loop1"); err != nil {
+			t.Fatalf("lstat: %v", err)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	modes := map[string][]githubfs.Option{
+		"eager":       nil,
+		"lazy":        {githubfs.WithLazyTrees()},
+		"placeholder": {githubfs.WithPlaceholder(".gitkeep")},
+	}
+	for name, opts := range modes {
+		t.Run(name, func(t *testing.T) {
+			srv := githubfstest.NewServer()
+			defer srv.Close()
+			srv.CreateRepo("o", "r", "master", nil)
+			fs, err := githubfs.New(srv.Client(), "o", "r", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := githubfstest.Conformance(fs); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
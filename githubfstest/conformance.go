@@ -0,0 +1,356 @@
+package githubfstest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var fixedTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// step is an operation whose outcome is compared between filesystems.
+type step struct {
+	name string
+	run  func(fs afero.Fs) (string, error)
+}
+
+// outcomeWant is an expected result and kind of error, as returned by
+// errKind.
+type outcomeWant struct {
+	result string
+	kind   string
+}
+
+// renamed are the expected outcomes of the steps after the directory
+// rename "Rename a/b", by step name. MemMapFs differs between afero
+// versions there, so these steps aren't compared against it.
+var renamed = map[string]outcomeWant{
+	"ReadFile a/d/f.txt":   {"hX", "ok"},
+	"ReadFile a/d/c/x.txt": {"x", "ok"},
+	"Stat a/b/f.txt":       {"", "not exist"},
+	"ReadDir a":            {"d dir", "ok"},
+	"ReadDir a/d":          {"c dir, f.txt file 2 executable", "ok"},
+}
+
+// Conformance runs a sequence of operations covering the afero.Fs and
+// afero.File interfaces against fs and against an afero.MemMapFs, and
+// returns an error describing every operation whose outcome differs. fs
+// must be empty and writable.
+//
+// Outcomes are compared by their results and by the kind of error, not by
+// the exact error. Empty directories, and operations where MemMapFs itself
+// differs from the os package, such as creating files in directories that
+// don't exist, are left out. The results of directory renames are checked
+// against fixed expectations, since older versions of MemMapFs don't move
+// the children of renamed directories.
+func Conformance(fs afero.Fs) error {
+	mem := afero.NewMemMapFs()
+	var diffs []string
+	afterRename := false
+	for _, s := range steps {
+		got, gotErr := s.run(fs)
+		// MemMapFs still runs every step to stay in step with fs
+		memGot, memErr := s.run(mem)
+		if s.name == "Rename a/b" {
+			afterRename = true
+		}
+		if w, ok := renamed[s.name]; ok && afterRename {
+			if got != w.result || errKind(gotErr) != w.kind {
+				diffs = append(diffs, fmt.Sprintf("%s: got %s, want %q (%s)", s.name, outcome(got, gotErr), w.result, w.kind))
+			}
+			continue
+		}
+		if got != memGot || errKind(gotErr) != errKind(memErr) {
+			diffs = append(diffs, fmt.Sprintf("%s: got %s, MemMapFs got %s", s.name, outcome(got, gotErr), outcome(memGot, memErr)))
+		}
+	}
+	if len(diffs) > 0 {
+		return errors.New("githubfstest: filesystem differs from MemMapFs:\n" + strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+func outcome(result string, err error) string {
+	if err != nil {
+		return fmt.Sprintf("%q (%v)", result, err)
+	}
+	return fmt.Sprintf("%q", result)
+}
+
+func errKind(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case err == io.EOF:
+		return "EOF"
+	case os.IsNotExist(err) || errors.Is(err, os.ErrNotExist):
+		return "not exist"
+	case os.IsExist(err) || errors.Is(err, os.ErrExist):
+		return "exist"
+	}
+	return "error"
+}
+
+func describe(fi os.FileInfo) string {
+	kind := "file"
+	if fi.IsDir() {
+		kind = "dir"
+	}
+	s := fmt.Sprintf("%s %s", fi.Name(), kind)
+	if !fi.IsDir() {
+		s += fmt.Sprintf(" %d", fi.Size())
+		if fi.Mode()&0100 != 0 {
+			s += " executable"
+		}
+	}
+	return s
+}
+
+func describeAll(infos []os.FileInfo) string {
+	var names []string
+	for _, fi := range infos {
+		names = append(names, describe(fi))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func mkdirAll(p string) step {
+	return step{"MkdirAll " + p, func(fs afero.Fs) (string, error) {
+		return "", fs.MkdirAll(p, 0755)
+	}}
+}
+
+func mkdir(p string) step {
+	return step{"Mkdir " + p, func(fs afero.Fs) (string, error) {
+		return "", fs.Mkdir(p, 0755)
+	}}
+}
+
+func writeFile(p, data string) step {
+	return step{"WriteFile " + p, func(fs afero.Fs) (string, error) {
+		return "", afero.WriteFile(fs, p, []byte(data), 0644)
+	}}
+}
+
+func readFile(p string) step {
+	return step{"ReadFile " + p, func(fs afero.Fs) (string, error) {
+		data, err := afero.ReadFile(fs, p)
+		return string(data), err
+	}}
+}
+
+func stat(p string) step {
+	return step{"Stat " + p, func(fs afero.Fs) (string, error) {
+		fi, err := fs.Stat(p)
+		if err != nil {
+			return "", err
+		}
+		if p == "/" {
+			// the name of the root varies
+			return fmt.Sprint(fi.IsDir()), nil
+		}
+		return describe(fi), nil
+	}}
+}
+
+func readDir(p string) step {
+	return step{"ReadDir " + p, func(fs afero.Fs) (string, error) {
+		infos, err := afero.ReadDir(fs, p)
+		return describeAll(infos), err
+	}}
+}
+
+// openFile opens p with flag and runs fn on the file, closing it after.
+func openFile(name, p string, flag int, fn func(f afero.File) (string, error)) step {
+	return step{name + " " + p, func(fs afero.Fs) (string, error) {
+		f, err := fs.OpenFile(p, flag, 0644)
+		if err != nil {
+			return "", err
+		}
+		result, err := fn(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return result, err
+	}}
+}
+
+func write(data string) func(f afero.File) (string, error) {
+	return func(f afero.File) (string, error) {
+		_, err := f.Write([]byte(data))
+		return "", err
+	}
+}
+
+var steps = []step{
+	mkdirAll("a/b/c"),
+	stat("a/b/c"),
+	mkdirAll("a/b/c"),
+	mkdir("a"),
+	mkdir("a/b/c"),
+	writeFile("a/b/c/x.txt", "x"),
+	writeFile("a/b/f.txt", "hello"),
+	readFile("a/b/f.txt"),
+	stat("a/b/f.txt"),
+	stat("a"),
+	stat("/"),
+	stat("missing"),
+	stat("a/b/f.txt/x"),
+	step{"Open missing", func(fs afero.Fs) (string, error) {
+		_, err := fs.Open("missing")
+		return "", err
+	}},
+	readDir("a/b"),
+	readDir("/"),
+	readDir("missing"),
+	step{"Readdirnames a", func(fs afero.Fs) (string, error) {
+		f, err := fs.Open("a")
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		sort.Strings(names)
+		return strings.Join(names, ", "), err
+	}},
+	step{"Readdir 1 a/b", func(fs afero.Fs) (string, error) {
+		f, err := fs.Open("a/b")
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		var results []string
+		for i := 0; i < 3; i++ {
+			infos, err := f.Readdir(1)
+			results = append(results, fmt.Sprintf("%d %s", len(infos), errKind(err)))
+		}
+		return strings.Join(results, ", "), nil
+	}},
+	step{"Create a/b/g.txt", func(fs afero.Fs) (string, error) {
+		f, err := fs.Create("a/b/g.txt")
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.WriteString("gee"); err != nil {
+			return "", err
+		}
+		return "", f.Close()
+	}},
+	readFile("a/b/g.txt"),
+	step{"Create existing a/b/g.txt", func(fs afero.Fs) (string, error) {
+		f, err := fs.Create("a/b/g.txt")
+		if err != nil {
+			return "", err
+		}
+		return "", f.Close()
+	}},
+	readFile("a/b/g.txt"),
+	openFile("OpenFile O_TRUNC", "a/b/f.txt", os.O_WRONLY|os.O_TRUNC, write("hi")),
+	readFile("a/b/f.txt"),
+	openFile("OpenFile O_EXCL existing", "a/b/f.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, write("no")),
+	readFile("a/b/f.txt"),
+	openFile("OpenFile O_EXCL", "a/h.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, write("h")),
+	readFile("a/h.txt"),
+	openFile("OpenFile O_APPEND", "a/b/f.txt", os.O_WRONLY|os.O_APPEND, write("!")),
+	readFile("a/b/f.txt"),
+	openFile("OpenFile O_RDONLY", "missing", os.O_RDONLY, write("")),
+	openFile("OpenFile O_RDONLY write", "a/b/f.txt", os.O_RDONLY, write("no")),
+	step{"Open write a/b/f.txt", func(fs afero.Fs) (string, error) {
+		f, err := fs.Open("a/b/f.txt")
+		if err != nil {
+			return "", err
+		}
+		_, err = f.Write([]byte("no"))
+		f.Close()
+		return "", err
+	}},
+	readFile("a/b/f.txt"),
+	openFile("Seek and read", "a/b/f.txt", os.O_RDONLY, func(f afero.File) (string, error) {
+		var results []string
+		buf := make([]byte, 1)
+		n, err := f.Read(buf)
+		results = append(results, fmt.Sprintf("read %q %s", buf[:n], errKind(err)))
+		off, err := f.Seek(0, io.SeekEnd)
+		results = append(results, fmt.Sprintf("seek end %d %s", off, errKind(err)))
+		n, err = f.Read(buf)
+		results = append(results, fmt.Sprintf("read %q %s", buf[:n], errKind(err)))
+		n, err = f.ReadAt(buf, 1)
+		results = append(results, fmt.Sprintf("read at 1 %q %s", buf[:n], errKind(err)))
+		off, err = f.Seek(1, io.SeekStart)
+		results = append(results, fmt.Sprintf("seek 1 %d %s", off, errKind(err)))
+		rest, err := afero.ReadAll(f)
+		results = append(results, fmt.Sprintf("read all %q %s", rest, errKind(err)))
+		fi, err := f.Stat()
+		if err != nil {
+			return "", err
+		}
+		results = append(results, "stat "+describe(fi))
+		return strings.Join(results, ", "), nil
+	}),
+	openFile("WriteAt and Truncate", "a/b/f.txt", os.O_RDWR, func(f afero.File) (string, error) {
+		if _, err := f.WriteAt([]byte("X"), 1); err != nil {
+			return "", err
+		}
+		return "", f.Truncate(2)
+	}),
+	readFile("a/b/f.txt"),
+	step{"Read closed file", func(fs afero.Fs) (string, error) {
+		f, err := fs.Open("a/b/f.txt")
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+		_, err = f.Read(make([]byte, 1))
+		return "", err
+	}},
+	step{"Chmod a/b/f.txt", func(fs afero.Fs) (string, error) {
+		return "", fs.Chmod("a/b/f.txt", 0755)
+	}},
+	stat("a/b/f.txt"),
+	step{"Chmod missing", func(fs afero.Fs) (string, error) {
+		return "", fs.Chmod("missing", 0755)
+	}},
+	step{"Chtimes missing", func(fs afero.Fs) (string, error) {
+		return "", fs.Chtimes("missing", fixedTime, fixedTime)
+	}},
+	step{"Rename a/h.txt", func(fs afero.Fs) (string, error) {
+		return "", fs.Rename("a/h.txt", "a/b/c/h.txt")
+	}},
+	readFile("a/b/c/h.txt"),
+	stat("a/h.txt"),
+	step{"Remove a/b/g.txt", func(fs afero.Fs) (string, error) {
+		return "", fs.Remove("a/b/g.txt")
+	}},
+	stat("a/b/g.txt"),
+	step{"Remove missing", func(fs afero.Fs) (string, error) {
+		return "", fs.Remove("a/b/g.txt")
+	}},
+	step{"Rename a/b", func(fs afero.Fs) (string, error) {
+		return "", fs.Rename("a/b", "a/d")
+	}},
+	readFile("a/d/f.txt"),
+	readFile("a/d/c/x.txt"),
+	stat("a/b/f.txt"),
+	readDir("a"),
+	readDir("a/d"),
+	step{"Rename missing", func(fs afero.Fs) (string, error) {
+		return "", fs.Rename("missing", "a/missing")
+	}},
+	writeFile("z.txt", "z"),
+	step{"RemoveAll a", func(fs afero.Fs) (string, error) {
+		return "", fs.RemoveAll("a")
+	}},
+	stat("a/d/f.txt"),
+	stat("a"),
+	readDir("/"),
+	step{"RemoveAll missing", func(fs afero.Fs) (string, error) {
+		return "", fs.RemoveAll("missing")
+	}},
+}
@@ -0,0 +1,760 @@
+// Package githubfstest provides an in-memory fake of the parts of the GitHub
+// API used by githubfs, for testing without network access:
+//
+//	srv := githubfstest.NewServer()
+//	defer srv.Close()
+//	srv.CreateRepo("owner", "repo", "master", map[string]string{"README.md": "hello"})
+//	fs, err := githubfs.New(srv.Client(), "owner", "repo")
+//
+// Any client works if its BaseURL is set to the server's URL, with a
+// trailing slash.
+package githubfstest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// Server is a fake GitHub API server backed by an in-memory object store.
+// Objects get the same SHAs they would get from git.
+type Server struct {
+	*httptest.Server
+
+	// MaxTreeEntries truncates recursive tree listings with more entries,
+	// like GitHub does for large trees. Zero means no limit.
+	MaxTreeEntries int
+
+	mu       sync.Mutex
+	repos    map[string]*repo
+	requests int
+}
+
+type repo struct {
+	defaultBranch string
+	blobs         map[string][]byte
+	trees         map[string][]entry
+	commits       map[string]*commit
+	tags          map[string]*tag
+	refs          map[string]string
+}
+
+type entry struct {
+	name string
+	mode string
+	typ  string
+	sha  string
+}
+
+type commit struct {
+	tree      string
+	parents   []string
+	message   string
+	author    github.CommitAuthor
+	committer github.CommitAuthor
+}
+
+type tag struct {
+	name   string
+	object string
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{repos: make(map[string]*repo)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns a GitHub client talking to the server.
+func (s *Server) Client() *github.Client {
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(s.URL + "/")
+	return client
+}
+
+// Requests returns the number of API requests served so far.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// CreateRepo creates a repository whose default branch holds files, keyed
+// by path, in a single commit. It returns the SHA of the commit.
+func (s *Server) CreateRepo(owner, name, branch string, files map[string]string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &repo{
+		defaultBranch: branch,
+		blobs:         make(map[string][]byte),
+		trees:         make(map[string][]entry),
+		commits:       make(map[string]*commit),
+		tags:          make(map[string]*tag),
+		refs:          make(map[string]string),
+	}
+	s.repos[owner+"/"+name] = r
+	root := r.putTree(nil)
+	r.refs["heads/"+branch] = r.commitFiles(root, nil, files, nil, "initial commit")
+	return r.refs["heads/"+branch]
+}
+
+// Commit commits files to branch, keyed by path, and removes the paths in
+// remove, as if pushed by someone else. It returns the SHA of the commit.
+func (s *Server) Commit(owner, name, branch string, files map[string]string, remove ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repos[owner+"/"+name]
+	head := r.refs["heads/"+branch]
+	r.refs["heads/"+branch] = r.commitFiles(r.commits[head].tree, []string{head}, files, remove, "external commit")
+	return r.refs["heads/"+branch]
+}
+
+// Tag creates a lightweight tag, or an annotated one, pointing at sha.
+func (s *Server) Tag(owner, name, tagName, sha string, annotated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repos[owner+"/"+name]
+	if annotated {
+		t := &tag{name: tagName, object: sha}
+		tsha := hashObject("tag", []byte(fmt.Sprintf("object %s\ntype commit\ntag %s\n", sha, tagName)))
+		r.tags[tsha] = t
+		sha = tsha
+	}
+	r.refs["tags/"+tagName] = sha
+}
+
+// Head returns the commit SHA branch points at.
+func (s *Server) Head(owner, name, branch string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repos[owner+"/"+name].refs["heads/"+branch]
+}
+
+// Files returns the contents of every file in the tree of the given
+// commit, keyed by path.
+func (s *Server) Files(owner, name, sha string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repos[owner+"/"+name]
+	files := make(map[string]string)
+	for _, e := range r.walk(r.commits[sha].tree, "") {
+		if e.typ == "blob" {
+			files[e.name] = string(r.blobs[e.sha])
+		}
+	}
+	return files
+}
+
+// Message returns the message of the commit with the given SHA.
+func (s *Server) Message(owner, name, sha string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repos[owner+"/"+name].commits[sha].message
+}
+
+func hashObject(typ string, data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", typ, len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *repo) putBlob(data []byte) string {
+	sha := hashObject("blob", data)
+	r.blobs[sha] = data
+	return sha
+}
+
+func (r *repo) putTree(entries []entry) string {
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+	var buf bytes.Buffer
+	for _, e := range entries {
+		mode := e.mode
+		if mode == "040000" {
+			mode = "40000"
+		}
+		raw, _ := hex.DecodeString(e.sha)
+		fmt.Fprintf(&buf, "%s %s\x00", mode, e.name)
+		buf.Write(raw)
+	}
+	sha := hashObject("tree", buf.Bytes())
+	r.trees[sha] = entries
+	return sha
+}
+
+// sortName is the name git sorts tree entries by.
+func sortName(e entry) string {
+	if e.typ == "tree" {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+func (r *repo) putCommit(c *commit) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", c.tree)
+	for _, p := range c.parents {
+		fmt.Fprintf(&buf, "parent %s\n", p)
+	}
+	fmt.Fprintf(&buf, "author %s <%s> %d +0000\n", c.author.GetName(), c.author.GetEmail(), c.author.GetDate().Unix())
+	fmt.Fprintf(&buf, "committer %s <%s> %d +0000\n\n%s", c.committer.GetName(), c.committer.GetEmail(), c.committer.GetDate().Unix(), c.message)
+	sha := hashObject("commit", buf.Bytes())
+	r.commits[sha] = c
+	return sha
+}
+
+// walk lists the tree recursively with full paths as names.
+func (r *repo) walk(sha string, prefix string) []entry {
+	var entries []entry
+	for _, e := range r.trees[sha] {
+		e.name = prefix + e.name
+		entries = append(entries, e)
+		if e.typ == "tree" {
+			entries = append(entries, r.walk(e.sha, e.name+"/")...)
+		}
+	}
+	return entries
+}
+
+// dir is a tree being edited.
+type dir struct {
+	entries map[string]entry
+	subdirs map[string]*dir
+}
+
+func (r *repo) edit(sha string) *dir {
+	d := &dir{entries: make(map[string]entry), subdirs: make(map[string]*dir)}
+	for _, e := range r.trees[sha] {
+		d.entries[e.name] = e
+	}
+	return d
+}
+
+// set puts e at the slash separated path p, creating directories on the
+// way. A nil e removes the path.
+func (r *repo) set(d *dir, p string, e *entry) error {
+	i := strings.Index(p, "/")
+	if i < 0 {
+		delete(d.subdirs, p)
+		if e == nil {
+			delete(d.entries, p)
+		} else {
+			e.name = p
+			d.entries[p] = *e
+		}
+		return nil
+	}
+	name := p[:i]
+	sub, ok := d.subdirs[name]
+	if !ok {
+		old, exists := d.entries[name]
+		if exists && old.typ != "tree" {
+			return fmt.Errorf("%s is not a directory", name)
+		}
+		if !exists && e == nil {
+			return nil
+		}
+		sub = r.edit(old.sha)
+		d.subdirs[name] = sub
+	}
+	return r.set(sub, p[i+1:], e)
+}
+
+// save stores the edited tree, leaving out empty subdirectories.
+func (r *repo) save(d *dir) string {
+	var entries []entry
+	for name, e := range d.entries {
+		if sub, ok := d.subdirs[name]; ok {
+			e.sha = r.save(sub)
+			if len(r.trees[e.sha]) == 0 {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	for name, sub := range d.subdirs {
+		if _, ok := d.entries[name]; ok {
+			continue
+		}
+		sha := r.save(sub)
+		if len(r.trees[sha]) == 0 {
+			continue
+		}
+		entries = append(entries, entry{name: name, mode: "040000", typ: "tree", sha: sha})
+	}
+	return r.putTree(entries)
+}
+
+func (r *repo) commitFiles(tree string, parents []string, files map[string]string, remove []string, message string) string {
+	d := r.edit(tree)
+	for p, content := range files {
+		r.set(d, p, &entry{mode: "100644", typ: "blob", sha: r.putBlob([]byte(content))})
+	}
+	for _, p := range remove {
+		r.set(d, p, nil)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	sig := github.CommitAuthor{Name: github.String("githubfstest"), Email: github.String("githubfstest@example.com"), Date: &now}
+	return r.putCommit(&commit{tree: r.save(d), parents: parents, message: message, author: sig, committer: sig})
+}
+
+// isAncestor reports whether a is b or one of its ancestors.
+func (r *repo) isAncestor(a, b string) bool {
+	if a == b {
+		return true
+	}
+	c, ok := r.commits[b]
+	if !ok {
+		return false
+	}
+	for _, p := range c.parents {
+		if r.isAncestor(a, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the entry at p in the given tree.
+func (r *repo) lookup(tree, p string) (entry, bool) {
+	parts := strings.Split(p, "/")
+	for i, name := range parts {
+		var found *entry
+		for _, e := range r.trees[tree] {
+			if e.name == name {
+				e := e
+				found = &e
+				break
+			}
+		}
+		if found == nil {
+			return entry{}, false
+		}
+		if i == len(parts)-1 {
+			return *found, true
+		}
+		tree = found.sha
+	}
+	return entry{}, false
+}
+
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string { return e.message }
+
+func errorf(status int, format string, a ...interface{}) error {
+	return &httpError{status, fmt.Sprintf(format, a...)}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	// the response is prepared under the lock and written after, so a
+	// client reading a response slowly doesn't hold up other requests
+	s.mu.Lock()
+	s.requests++
+	v, err := s.handle(req)
+	var body bytes.Buffer
+	if err == nil && v != nil {
+		if _, ok := v.(rawContent); !ok {
+			json.NewEncoder(&body).Encode(v)
+		}
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if e, ok := err.(*httpError); ok {
+			status = e.status
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+		return
+	}
+	if data, ok := v.(rawContent); ok {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+		return
+	}
+	if v == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method == "POST" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	w.Write(body.Bytes())
+}
+
+// rawContent is returned by handle for responses served as they are, with
+// support for range requests.
+type rawContent []byte
+
+// handle serves a request, returning the value to encode as the response.
+// It is called with s.mu held.
+func (s *Server) handle(req *http.Request) (interface{}, error) {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 5)
+	if len(parts) < 3 || parts[0] != "repos" {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	r, ok := s.repos[parts[1]+"/"+parts[2]]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	if len(parts) == 3 {
+		return &github.Repository{Name: github.String(parts[2]), DefaultBranch: github.String(r.defaultBranch)}, nil
+	}
+	rest := strings.Join(parts[3:], "/")
+	switch {
+	case strings.HasPrefix(rest, "branches/") && req.Method == "GET":
+		return r.getBranch(strings.TrimPrefix(rest, "branches/"))
+	case rest == "commits" && req.Method == "GET":
+		return r.listCommits(req.URL.Query())
+	case strings.HasPrefix(rest, "contents/") && req.Method == "DELETE":
+		return r.deleteFile(req, strings.TrimPrefix(rest, "contents/"))
+	case strings.HasPrefix(rest, "git/blobs/") && req.Method == "GET":
+		return r.getBlob(req, strings.TrimPrefix(rest, "git/blobs/"))
+	case rest == "git/blobs" && req.Method == "POST":
+		return r.createBlob(req)
+	case strings.HasPrefix(rest, "git/trees/") && req.Method == "GET":
+		return r.getTree(strings.TrimPrefix(rest, "git/trees/"), req.URL.Query().Get("recursive") != "", s.MaxTreeEntries)
+	case rest == "git/trees" && req.Method == "POST":
+		return r.createTree(req)
+	case strings.HasPrefix(rest, "git/commits/") && req.Method == "GET":
+		return r.getCommit(strings.TrimPrefix(rest, "git/commits/"))
+	case rest == "git/commits" && req.Method == "POST":
+		return r.createCommit(req)
+	case strings.HasPrefix(rest, "git/refs/") && req.Method == "GET":
+		return r.getRef(strings.TrimPrefix(rest, "git/refs/"))
+	case strings.HasPrefix(rest, "git/refs/") && req.Method == "PATCH":
+		return r.updateRef(req, strings.TrimPrefix(rest, "git/refs/"))
+	case strings.HasPrefix(rest, "git/tags/") && req.Method == "GET":
+		return r.getTag(strings.TrimPrefix(rest, "git/tags/"))
+	}
+	return nil, errorf(http.StatusNotFound, "Not Found")
+}
+
+func decode(req *http.Request, v interface{}) error {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "Problems parsing JSON")
+	}
+	return nil
+}
+
+func (r *repo) repositoryCommit(sha string) *github.RepositoryCommit {
+	c := r.gitCommit(sha)
+	return &github.RepositoryCommit{SHA: c.SHA, Commit: c, Parents: c.Parents}
+}
+
+func (r *repo) gitCommit(sha string) *github.Commit {
+	c := r.commits[sha]
+	gc := &github.Commit{
+		SHA:       github.String(sha),
+		Tree:      &github.Tree{SHA: github.String(c.tree)},
+		Message:   github.String(c.message),
+		Author:    &c.author,
+		Committer: &c.committer,
+	}
+	for _, p := range c.parents {
+		gc.Parents = append(gc.Parents, github.Commit{SHA: github.String(p)})
+	}
+	return gc
+}
+
+func (r *repo) getBranch(name string) (interface{}, error) {
+	sha, ok := r.refs["heads/"+name]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Branch not found")
+	}
+	return &github.Branch{Name: github.String(name), Commit: r.repositoryCommit(sha)}, nil
+}
+
+func (r *repo) listCommits(q url.Values) (interface{}, error) {
+	sha := q.Get("sha")
+	if head, ok := r.refs["heads/"+sha]; ok {
+		sha = head
+	}
+	if _, ok := r.commits[sha]; !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	perPage, _ := strconv.Atoi(q.Get("per_page"))
+	p := q.Get("path")
+	commits := []*github.RepositoryCommit{}
+	// first parent history, keeping commits that changed p
+	for sha != "" {
+		c := r.commits[sha]
+		parent := ""
+		if len(c.parents) > 0 {
+			parent = c.parents[0]
+		}
+		changed := true
+		if p != "" {
+			e, ok := r.lookup(c.tree, p)
+			if parent != "" {
+				pe, pok := r.lookup(r.commits[parent].tree, p)
+				changed = ok != pok || e.sha != pe.sha || e.mode != pe.mode
+			} else {
+				changed = ok
+			}
+		}
+		if changed {
+			commits = append(commits, r.repositoryCommit(sha))
+			if perPage > 0 && len(commits) == perPage {
+				break
+			}
+		}
+		sha = parent
+	}
+	return commits, nil
+}
+
+func (r *repo) deleteFile(req *http.Request, p string) (interface{}, error) {
+	var opts github.RepositoryContentFileOptions
+	if err := decode(req, &opts); err != nil {
+		return nil, err
+	}
+	branch := opts.GetBranch()
+	if branch == "" {
+		branch = r.defaultBranch
+	}
+	head, ok := r.refs["heads/"+branch]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Branch not found")
+	}
+	e, ok := r.lookup(r.commits[head].tree, p)
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	if e.sha != opts.GetSHA() {
+		return nil, errorf(http.StatusConflict, "%s does not match %s", p, opts.GetSHA())
+	}
+	d := r.edit(r.commits[head].tree)
+	r.set(d, p, nil)
+	c := &commit{tree: r.save(d), parents: []string{head}, message: opts.GetMessage()}
+	c.author, c.committer = signature(opts.Author), signature(opts.Committer)
+	sha := r.putCommit(c)
+	r.refs["heads/"+branch] = sha
+	return &github.RepositoryContentResponse{Commit: *r.gitCommit(sha)}, nil
+}
+
+func signature(a *github.CommitAuthor) github.CommitAuthor {
+	sig := github.CommitAuthor{Name: github.String("githubfstest"), Email: github.String("githubfstest@example.com")}
+	if a != nil {
+		sig = *a
+	}
+	if sig.Date == nil {
+		now := time.Now().UTC().Truncate(time.Second)
+		sig.Date = &now
+	}
+	return sig
+}
+
+func (r *repo) getBlob(req *http.Request, sha string) (interface{}, error) {
+	data, ok := r.blobs[sha]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	if strings.Contains(req.Header.Get("Accept"), "raw") {
+		return rawContent(append([]byte(nil), data...)), nil
+	}
+	return &github.Blob{
+		SHA:      github.String(sha),
+		Content:  github.String(base64.StdEncoding.EncodeToString(data)),
+		Encoding: github.String("base64"),
+		Size:     github.Int(len(data)),
+	}, nil
+}
+
+func (r *repo) createBlob(req *http.Request) (interface{}, error) {
+	var blob github.Blob
+	if err := decode(req, &blob); err != nil {
+		return nil, err
+	}
+	data := []byte(blob.GetContent())
+	if blob.GetEncoding() == "base64" {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(blob.GetContent()); err != nil {
+			return nil, errorf(http.StatusUnprocessableEntity, "invalid base64 content")
+		}
+	}
+	return &github.Blob{SHA: github.String(r.putBlob(data))}, nil
+}
+
+func (r *repo) treeEntry(e entry) github.TreeEntry {
+	te := github.TreeEntry{
+		SHA:  github.String(e.sha),
+		Path: github.String(e.name),
+		Mode: github.String(e.mode),
+		Type: github.String(e.typ),
+	}
+	if e.typ == "blob" {
+		te.Size = github.Int(len(r.blobs[e.sha]))
+	}
+	return te
+}
+
+func (r *repo) getTree(sha string, recursive bool, max int) (interface{}, error) {
+	entries, ok := r.trees[sha]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	tree := &github.Tree{SHA: github.String(sha), Truncated: github.Bool(false)}
+	if recursive {
+		entries = r.walk(sha, "")
+		if max > 0 && len(entries) > max {
+			entries = entries[:max]
+			tree.Truncated = github.Bool(true)
+		}
+	}
+	for _, e := range entries {
+		tree.Entries = append(tree.Entries, r.treeEntry(e))
+	}
+	return tree, nil
+}
+
+func (r *repo) createTree(req *http.Request) (interface{}, error) {
+	var body struct {
+		BaseTree string             `json:"base_tree"`
+		Entries  []github.TreeEntry `json:"tree"`
+	}
+	if err := decode(req, &body); err != nil {
+		return nil, err
+	}
+	if body.BaseTree != "" {
+		if _, ok := r.trees[body.BaseTree]; !ok {
+			return nil, errorf(http.StatusUnprocessableEntity, "base_tree is invalid")
+		}
+	}
+	d := r.edit(body.BaseTree)
+	for _, te := range body.Entries {
+		e := entry{mode: te.GetMode(), typ: te.GetType(), sha: te.GetSHA()}
+		switch {
+		case te.Content != nil:
+			e.sha = r.putBlob([]byte(te.GetContent()))
+		case e.sha == "":
+			return nil, errorf(http.StatusUnprocessableEntity, "Must supply tree.sha or tree.content")
+		case e.typ == "tree":
+			if _, ok := r.trees[e.sha]; !ok {
+				return nil, errorf(http.StatusUnprocessableEntity, "tree.sha %s is not a valid tree", e.sha)
+			}
+		case e.typ == "blob":
+			if _, ok := r.blobs[e.sha]; !ok {
+				return nil, errorf(http.StatusUnprocessableEntity, "tree.sha %s is not a valid blob", e.sha)
+			}
+		}
+		if err := r.set(d, path.Clean(te.GetPath()), &e); err != nil {
+			return nil, errorf(http.StatusUnprocessableEntity, "%v", err)
+		}
+	}
+	sha := r.save(d)
+	tree := &github.Tree{SHA: github.String(sha)}
+	for _, e := range r.trees[sha] {
+		tree.Entries = append(tree.Entries, r.treeEntry(e))
+	}
+	return tree, nil
+}
+
+func (r *repo) getCommit(sha string) (interface{}, error) {
+	if _, ok := r.commits[sha]; !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	return r.gitCommit(sha), nil
+}
+
+func (r *repo) createCommit(req *http.Request) (interface{}, error) {
+	var body struct {
+		Message   string               `json:"message"`
+		Tree      string               `json:"tree"`
+		Parents   []string             `json:"parents"`
+		Author    *github.CommitAuthor `json:"author"`
+		Committer *github.CommitAuthor `json:"committer"`
+	}
+	if err := decode(req, &body); err != nil {
+		return nil, err
+	}
+	if _, ok := r.trees[body.Tree]; !ok {
+		return nil, errorf(http.StatusUnprocessableEntity, "Tree SHA does not exist")
+	}
+	for _, p := range body.Parents {
+		if _, ok := r.commits[p]; !ok {
+			return nil, errorf(http.StatusUnprocessableEntity, "Parent SHA does not exist or is not a commit object")
+		}
+	}
+	c := &commit{tree: body.Tree, parents: body.Parents, message: body.Message}
+	c.author = signature(body.Author)
+	c.committer = signature(body.Committer)
+	return r.gitCommit(r.putCommit(c)), nil
+}
+
+func (r *repo) reference(ref, sha string) *github.Reference {
+	typ := "commit"
+	if _, ok := r.tags[sha]; ok {
+		typ = "tag"
+	}
+	return &github.Reference{
+		Ref:    github.String("refs/" + ref),
+		Object: &github.GitObject{Type: github.String(typ), SHA: github.String(sha)},
+	}
+}
+
+func (r *repo) getRef(ref string) (interface{}, error) {
+	sha, ok := r.refs[ref]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	return r.reference(ref, sha), nil
+}
+
+func (r *repo) updateRef(req *http.Request, ref string) (interface{}, error) {
+	var body struct {
+		SHA   string `json:"sha"`
+		Force bool   `json:"force"`
+	}
+	if err := decode(req, &body); err != nil {
+		return nil, err
+	}
+	old, ok := r.refs[ref]
+	if !ok {
+		return nil, errorf(http.StatusUnprocessableEntity, "Reference does not exist")
+	}
+	if _, ok := r.commits[body.SHA]; !ok {
+		return nil, errorf(http.StatusUnprocessableEntity, "Object does not exist")
+	}
+	if !body.Force && !r.isAncestor(old, body.SHA) {
+		return nil, errorf(http.StatusUnprocessableEntity, "Update is not a fast forward")
+	}
+	r.refs[ref] = body.SHA
+	return r.reference(ref, body.SHA), nil
+}
+
+func (r *repo) getTag(sha string) (interface{}, error) {
+	t, ok := r.tags[sha]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "Not Found")
+	}
+	return &github.Tag{
+		Tag:    github.String(t.name),
+		SHA:    github.String(sha),
+		Object: &github.GitObject{Type: github.String("commit"), SHA: github.String(t.object)},
+	}, nil
+}
@@ -0,0 +1,112 @@
+package githubfs
+
+import (
+	"path"
+
+	"github.com/google/go-github/github"
+)
+
+// index maps the paths of a tree's entries to their position in Entries,
+// and each directory to the positions of its children, so lookups don't
+// have to scan the whole tree. The root directory is keyed by "".
+type index struct {
+	tree     *github.Tree
+	n        int
+	paths    map[string]int
+	children map[string][]int
+}
+
+func newIndex(t *github.Tree) *index {
+	idx := &index{
+		tree:     t,
+		paths:    make(map[string]int, len(t.Entries)),
+		children: make(map[string][]int),
+	}
+	for i, e := range t.Entries {
+		idx.add(i, e)
+	}
+	return idx
+}
+
+func (idx *index) add(i int, e github.TreeEntry) {
+	if _, ok := idx.paths[e.GetPath()]; !ok {
+		idx.paths[e.GetPath()] = i
+	}
+	dir := parentDir(e.GetPath())
+	idx.children[dir] = append(idx.children[dir], i)
+	idx.n++
+}
+
+// valid reports whether idx still describes t. Changes other than appending
+// through the index must reset it explicitly.
+func (idx *index) valid(t *github.Tree) bool {
+	return idx != nil && idx.tree == t && idx.n == len(t.Entries)
+}
+
+// append adds e to the indexed tree.
+func (idx *index) append(e github.TreeEntry) {
+	idx.tree.Entries = append(idx.tree.Entries, e)
+	idx.add(len(idx.tree.Entries)-1, e)
+}
+
+// pos returns the position of the entry at p.
+func (idx *index) pos(p string) (int, bool) {
+	i, ok := idx.paths[p]
+	return i, ok
+}
+
+// get returns a copy of the entry at p, or nil if there is none.
+func (idx *index) get(p string) *github.TreeEntry {
+	i, ok := idx.paths[p]
+	if !ok {
+		return nil
+	}
+	e := idx.tree.Entries[i]
+	return &e
+}
+
+// list returns copies of the entries directly inside dir.
+func (idx *index) list(dir string) []github.TreeEntry {
+	var entries []github.TreeEntry
+	for _, i := range idx.children[dir] {
+		entries = append(entries, idx.tree.Entries[i])
+	}
+	return entries
+}
+
+// walk calls fn with the entries below dir, parents before their children.
+func (idx *index) walk(dir string, fn func(e github.TreeEntry)) {
+	for _, i := range idx.children[dir] {
+		e := idx.tree.Entries[i]
+		fn(e)
+		if e.GetType() == "tree" {
+			idx.walk(e.GetPath(), fn)
+		}
+	}
+}
+
+// parentDir returns the directory containing p, "" for the root.
+func parentDir(p string) string {
+	d := path.Dir(p)
+	if d == "." || d == "/" {
+		return ""
+	}
+	return d
+}
+
+// indexed returns the index of the in-memory tree, rebuilding it if the
+// tree was replaced or changed since it was built.
+func (fs *githubFs) indexed() *index {
+	if !fs.index.valid(fs.tree) {
+		fs.index = newIndex(fs.tree)
+	}
+	return fs.index
+}
+
+// baseIndexed returns the index of the base tree.
+func (fs *githubFs) baseIndexed() *index {
+	if !fs.baseIndex.valid(fs.base) {
+		fs.baseIndex = newIndex(fs.base)
+	}
+	return fs.baseIndex
+}
@@ -0,0 +1,113 @@
+package githubfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// benchFs returns a filesystem over a tree of 100 directories holding 1000
+// files each, without a server behind it.
+func benchFs(b *testing.B) *githubFs {
+	tree := &github.Tree{SHA: String("root")}
+	for d := 0; d < 100; d++ {
+		dir := fmt.Sprintf("d%03d", d)
+		tree.Entries = append(tree.Entries, github.TreeEntry{
+			Type: String("tree"),
+			Mode: String("040000"),
+			Path: String(dir),
+			SHA:  String(dir),
+		})
+		for f := 0; f < 1000; f++ {
+			tree.Entries = append(tree.Entries, github.TreeEntry{
+				Type: String("blob"),
+				Mode: String("100644"),
+				Path: String(fmt.Sprintf("%s/f%04d", dir, f)),
+				SHA:  String(fmt.Sprintf("%s/%d", dir, f)),
+				Size: github.Int(f),
+			})
+		}
+	}
+	fs := &githubFs{state: &state{tree: tree, branch: &github.Branch{}}}
+	// the index is built on first use
+	fs.indexed()
+	b.ResetTimer()
+	return fs
+}
+
+// scanEntry finds the entry at p by scanning the whole tree, as lookups did
+// before the index. It is the baseline for BenchmarkLookup.
+func scanEntry(tree *github.Tree, p string) *github.TreeEntry {
+	for i := range tree.Entries {
+		if tree.Entries[i].GetPath() == p {
+			return &tree.Entries[i]
+		}
+	}
+	return nil
+}
+
+// scanDir lists the entries directly inside dir by scanning the whole tree,
+// as directory listings did before the index. It is the baseline for
+// BenchmarkOpenReaddir.
+func scanDir(tree *github.Tree, dir string) []github.TreeEntry {
+	var entries []github.TreeEntry
+	for _, e := range tree.Entries {
+		if parentDir(e.GetPath()) == dir {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func BenchmarkStat(b *testing.B) {
+	fs := benchFs(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.Stat(fmt.Sprintf("d%03d/f%04d", i%100, i%1000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	fs := benchFs(b)
+	for i := 0; i < b.N; i++ {
+		e, err := fs.lookup(fmt.Sprintf("d%03d/f%04d", i%100, i%1000))
+		if err != nil || e == nil {
+			b.Fatal(e, err)
+		}
+	}
+}
+
+func BenchmarkLookupScan(b *testing.B) {
+	fs := benchFs(b)
+	for i := 0; i < b.N; i++ {
+		if e := scanEntry(fs.tree, fmt.Sprintf("d%03d/f%04d", i%100, i%1000)); e == nil {
+			b.Fatal("not found")
+		}
+	}
+}
+
+func BenchmarkOpenReaddir(b *testing.B) {
+	fs := benchFs(b)
+	for i := 0; i < b.N; i++ {
+		f, err := fs.Open(fmt.Sprintf("d%03d", i%100))
+		if err != nil {
+			b.Fatal(err)
+		}
+		infos, err := f.Readdir(-1)
+		if err != nil || len(infos) != 1000 {
+			b.Fatal(len(infos), err)
+		}
+		f.Close()
+	}
+}
+
+func BenchmarkReaddirScan(b *testing.B) {
+	fs := benchFs(b)
+	for i := 0; i < b.N; i++ {
+		if entries := scanDir(fs.tree, fmt.Sprintf("d%03d", i%100)); len(entries) != 1000 {
+			b.Fatal(len(entries))
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package githubfs
+
+// EntryInfo describes the git object behind a file. It is returned by the
+// Sys method of the FileInfos of a filesystem.
+type EntryInfo struct {
+	// SHA is the SHA of the blob or tree, or for submodules, of the
+	// commit the submodule is pinned to.
+	SHA string
+
+	// Mode is the git file mode, e.g. 100644.
+	Mode string
+
+	// Type is blob, tree or, for submodules, commit.
+	Type string
+
+	// Size is the size of a blob.
+	Size int64
+
+	// LastCommit is the SHA of the last commit that changed the path. It
+	// is only known with ModTimeHistory.
+	LastCommit string
+}
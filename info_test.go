@@ -0,0 +1,72 @@
+package githubfs_test
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"testing"
+
+	githubfs "github.com/progrium/go-githubfs"
+)
+
+// gitBlobSHA returns the SHA git gives a blob with the given contents.
+func gitBlobSHA(data string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(data), data))))
+}
+
+func entryInfo(t *testing.T, fi os.FileInfo) *githubfs.EntryInfo {
+	t.Helper()
+	info, ok := fi.Sys().(*githubfs.EntryInfo)
+	if !ok {
+		t.Fatalf("sys of %s is %T", fi.Name(), fi.Sys())
+	}
+	return info
+}
+
+func TestEntryInfo(t *testing.T) {
+	_, fs := newTestFs(t)
+	fi, err := fs.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := entryInfo(t, fi)
+	if info.SHA != gitBlobSHA("hello") || info.Mode != "100644" || info.Type != "blob" || info.Size != 5 {
+		t.Fatalf("sys of a.txt %+v", info)
+	}
+	fi, err = fs.Stat("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := entryInfo(t, fi); info.SHA == "" || info.Mode != "040000" || info.Type != "tree" {
+		t.Fatalf("sys of dir %+v", info)
+	}
+
+	if err := fs.Chmod("a.txt", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("a.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("HELLO!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := entryInfo(t, fi); info.SHA != gitBlobSHA("HELLO!") || info.Mode != "100755" || info.Size != 6 {
+		t.Fatalf("sys of the open file %+v", info)
+	}
+	f.Close()
+	fi, err = fs.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := entryInfo(t, fi); info.SHA != gitBlobSHA("HELLO!") || info.Size != 6 {
+		t.Fatalf("sys after writing %+v", info)
+	}
+}
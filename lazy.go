@@ -0,0 +1,117 @@
+package githubfs
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// lookup returns the entry at name, or nil if there is none, loading its
+// directory first if trees are loaded lazily.
+func (fs *githubFs) lookup(name string) (*github.TreeEntry, error) {
+	normalName := strings.TrimPrefix(name, "/")
+	if err := fs.loadDir(path.Dir(normalName)); err != nil {
+		return nil, err
+	}
+	return fs.findEntry(normalName), nil
+}
+
+// loadDir adds the entries of the directory dir, and of its parents, to
+// the in-memory tree if they haven't been loaded yet. It is a no-op unless
+// trees are loaded lazily.
+func (fs *githubFs) loadDir(dir string) error {
+	if dir == "." || dir == "/" {
+		dir = ""
+	}
+	if !fs.lazy || fs.loaded[dir] {
+		return nil
+	}
+	if err := fs.loadDir(path.Dir(dir)); err != nil {
+		return err
+	}
+	entry := fs.findEntry(dir)
+	if entry == nil || entry.GetType() != "tree" || entry.SHA == nil {
+		// nothing to list
+		return nil
+	}
+	entries, err := fs.listDir(entry.GetSHA())
+	if err != nil {
+		return err
+	}
+	// the base tree gets the listing too if the directory is unchanged,
+	// so pending changes can still be told apart
+	base := fs.baseIndexed().get(dir).GetSHA() == entry.GetSHA()
+	for _, e := range entries {
+		e.Path = String(dir + "/" + e.GetPath())
+		fs.indexed().append(e)
+		if base {
+			fs.baseIndexed().append(e)
+		}
+	}
+	fs.loaded[dir] = true
+	return nil
+}
+
+// listDir returns the entries of the tree with the given SHA, without
+// recursing into subtrees. Listings are memoized since trees are immutable.
+func (fs *githubFs) listDir(sha string) ([]github.TreeEntry, error) {
+	if entries, ok := fs.trees[sha]; ok {
+		return entries, nil
+	}
+	tree, _, err := fs.client.Git.GetTree(fs.ctx, fs.user, fs.repo, sha, false)
+	if err != nil {
+		return nil, err
+	}
+	if tree.GetTruncated() {
+		return nil, ErrIncompleteTree
+	}
+	fs.trees[sha] = tree.Entries
+	return tree.Entries, nil
+}
+
+// view lists the tree with the given SHA the same way the in-memory tree
+// is: completely, or if trees are loaded lazily, only the root and the
+// loaded directories.
+func (fs *githubFs) view(sha string) (*github.Tree, error) {
+	if !fs.lazy {
+		tree, complete, err := fs.getTree(sha)
+		if err != nil {
+			return nil, err
+		}
+		if !complete {
+			return nil, ErrIncompleteTree
+		}
+		return tree, nil
+	}
+	entries, err := fs.listDir(sha)
+	if err != nil {
+		return nil, err
+	}
+	tree := &github.Tree{SHA: String(sha), Entries: append([]github.TreeEntry(nil), entries...)}
+	idx := newIndex(tree)
+	var dirs []string
+	for d := range fs.loaded {
+		if d != "" {
+			dirs = append(dirs, d)
+		}
+	}
+	// parents sort before their children
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		e := idx.get(d)
+		if e == nil || e.GetType() != "tree" {
+			continue
+		}
+		children, err := fs.listDir(e.GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			c.Path = String(d + "/" + c.GetPath())
+			idx.append(c)
+		}
+	}
+	return tree, nil
+}
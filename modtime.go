@@ -0,0 +1,84 @@
+package githubfs
+
+import (
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// ModTimePolicy decides where the modification times of files come from.
+// Git doesn't store them, so they are derived from commits.
+type ModTimePolicy int
+
+const (
+	// ModTimeNone reports the time a file was opened, and the zero time
+	// for directories.
+	ModTimeNone ModTimePolicy = iota
+
+	// ModTimeHead reports the time of the head commit for every file. It
+	// makes no API calls.
+	ModTimeHead
+
+	// ModTimeHistory reports the time of the last commit that changed
+	// each path. It is looked up with the commits API the first time the
+	// modification time of a path is asked for, and cached per head
+	// commit.
+	ModTimeHistory
+)
+
+// pathCommit is the last commit that changed a path.
+type pathCommit struct {
+	sha  string
+	time time.Time
+}
+
+// headTime returns the commit time of the head commit.
+func (fs *githubFs) headTime() time.Time {
+	return fs.branch.GetCommit().GetCommit().GetCommitter().GetDate()
+}
+
+// deriveModTime sets the modification time of fd, the data of the path p,
+// according to the policy of fs.
+func (fs *githubFs) deriveModTime(fd *FileData, p string) {
+	switch fs.modTimes {
+	case ModTimeHead:
+		fd.modtime = fs.headTime()
+	case ModTimeHistory:
+		head, headTime := fs.branch.GetCommit().GetSHA(), fs.headTime()
+		fd.modtime = headTime
+		fd.lastCommit = func() (pathCommit, error) {
+			return fs.lastCommit(head, p)
+		}
+	}
+}
+
+// lastCommit returns the last commit before or at head that changed p.
+func (fs *githubFs) lastCommit(head string, p string) (pathCommit, error) {
+	key := head + ":" + p
+	fs.historyMu.Lock()
+	c, ok := fs.history[key]
+	fs.historyMu.Unlock()
+	if ok {
+		return c, nil
+	}
+	commits, _, err := fs.client.Repositories.ListCommits(fs.ctx, fs.user, fs.repo, &github.CommitsListOptions{
+		SHA:         head,
+		Path:        p,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return pathCommit{}, err
+	}
+	if len(commits) == 0 {
+		// not committed yet
+		return pathCommit{time: time.Now()}, nil
+	}
+	c = pathCommit{
+		sha:  commits[0].GetSHA(),
+		time: commits[0].GetCommit().GetCommitter().GetDate(),
+	}
+	fs.historyMu.Lock()
+	fs.history[key] = c
+	fs.historyMu.Unlock()
+	return c, nil
+}
@@ -0,0 +1,110 @@
+package githubfs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/progrium/go-githubfs/githubfstest"
+)
+
+// commitAt commits the file name with data to master, dated date, and
+// returns the commit SHA.
+func commitAt(t *testing.T, srv *githubfstest.Server, name, data string, date time.Time) string {
+	t.Helper()
+	ctx := context.Background()
+	c := srv.Client()
+	branch, _, err := c.Repositories.GetBranch(ctx, "o", "r", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _, err := c.Git.CreateTree(ctx, "o", "r", branch.GetCommit().GetCommit().GetTree().GetSHA(), []github.TreeEntry{{
+		Path:    github.String(name),
+		Mode:    github.String("100644"),
+		Type:    github.String("blob"),
+		Content: github.String(data),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := &github.CommitAuthor{Name: github.String("o"), Email: github.String("o@example.com"), Date: &date}
+	commit, _, err := c.Git.CreateCommit(ctx, "o", "r", &github.Commit{
+		Message:   github.String("dated"),
+		Tree:      tree,
+		Parents:   []github.Commit{{SHA: branch.GetCommit().SHA}},
+		Author:    sig,
+		Committer: sig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := &github.Reference{Ref: github.String("heads/master"), Object: &github.GitObject{SHA: commit.SHA}}
+	if _, _, err := c.Git.UpdateRef(ctx, "o", "r", ref, false); err != nil {
+		t.Fatal(err)
+	}
+	return commit.GetSHA()
+}
+
+func TestModTimes(t *testing.T) {
+	srv, _ := newTestFs(t)
+	first := srv.Head("o", "r", "master")
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := commitAt(t, srv, "a.txt", "old", older)
+	b := commitAt(t, srv, "dir/b.txt", "new", newer)
+	commit, _, err := srv.Client().Git.GetCommit(context.Background(), "o", "r", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := commit.GetCommitter().GetDate()
+
+	fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithModTimes(githubfs.ModTimeHead))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(newer) {
+			t.Fatalf("head mod time of %s is %v", name, fi.ModTime())
+		}
+	}
+
+	fs, err = githubfs.New(srv.Client(), "o", "r", githubfs.WithModTimes(githubfs.ModTimeHistory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		sha  string
+		time time.Time
+	}{
+		"a.txt":         {a, older},
+		"dir/b.txt":     {b, newer},
+		"dir/sub/c.txt": {first, created},
+	}
+	for name, w := range want {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(w.time) {
+			t.Fatalf("mod time of %s is %v, want %v", name, fi.ModTime(), w.time)
+		}
+		if sha := fi.Sys().(*githubfs.EntryInfo).LastCommit; sha != w.sha {
+			t.Fatalf("last commit of %s is %s, want %s", name, sha, w.sha)
+		}
+	}
+	// the history of a path is only looked up once per head
+	n := srv.Requests()
+	fi, err := fs.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(older) || srv.Requests() != n {
+		t.Fatalf("mod time %v looked up again with %d requests", fi.ModTime(), srv.Requests()-n)
+	}
+}
@@ -0,0 +1,216 @@
+package githubfs
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+// Options configures a filesystem.
+type Options struct {
+	// Branch is the branch the filesystem reads and commits to. Defaults
+	// to the repository's default branch.
+	Branch string
+
+	// Ref is a branch, tag or commit SHA to mount read-only. It takes
+	// precedence over Branch.
+	Ref string
+
+	// Commit configures the commits made by the filesystem.
+	Commit CommitOptions
+
+	// Context is used by the GitHub API calls made by New and by the
+	// filesystem it returns, including the mounts of submodules. Views
+	// made with WithContext use their own. Defaults to
+	// context.Background().
+	Context context.Context
+
+	// ReadOnly makes every mutating operation fail with os.ErrPermission
+	// before any API call is made. Files are opened with read-only handles,
+	// which never commit on Close.
+	ReadOnly bool
+
+	// LazyTrees lists each directory on first access instead of listing
+	// the whole repository up front, which keeps mounting large
+	// repositories fast and memory proportional to what is accessed.
+	LazyTrees bool
+
+	// Cache stores blob contents by SHA. If nil, blobs are not cached.
+	Cache BlobCache
+
+	// BaseURL is the API URL of a GitHub Enterprise server, e.g.
+	// https://github.example.com/api/v3/. If set, requests are sent there
+	// with HTTPClient instead of through the client given to New, which is
+	// left unchanged.
+	BaseURL string
+
+	// HTTPClient sends the requests to BaseURL and carries their
+	// authentication. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Logger receives a line for every commit and retry. If nil, nothing
+	// is logged.
+	Logger Logger
+
+	// ConflictPolicy decides what happens when the branch has moved since
+	// the filesystem last synced with it. Defaults to ConflictFail.
+	ConflictPolicy ConflictPolicy
+
+	// Submodules mounts each submodule as a read-only filesystem of its
+	// repository at the pinned commit, found through .gitmodules. Only
+	// relative urls and urls on the host of the API can be mounted.
+	// Otherwise submodules are empty directories.
+	Submodules bool
+
+	// ModTimes decides where the modification times of files come from.
+	// Defaults to ModTimeNone.
+	ModTimes ModTimePolicy
+
+	// Placeholder is the name of an empty file, such as ".gitkeep", that
+	// is written to directories made with Mkdir and to directories left
+	// empty, since git can't store empty directories. It is hidden from
+	// directory listings and removed once something else is stored in the
+	// directory. If empty, empty directories only exist until the next
+	// commit.
+	Placeholder string
+
+	// StreamThreshold is the size in bytes from which files are read with
+	// HTTP range requests for their raw contents, instead of being
+	// downloaded whole and held in memory. Sequential reads share one
+	// response, which stays open until the file is closed; a new request
+	// is only made when reading from another offset. Files are still
+	// downloaded whole when written to, or if the server turns out not to
+	// support range requests. If zero, files are never streamed.
+	StreamThreshold int64
+}
+
+// Option configures a filesystem created with New.
+type Option func(*Options)
+
+// Logger is implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithBranch sets the branch the filesystem reads and commits to.
+func WithBranch(branch string) Option {
+	return func(o *Options) {
+		o.Branch = branch
+	}
+}
+
+// WithRef mounts a branch, tag or commit SHA read-only.
+func WithRef(ref string) Option {
+	return func(o *Options) {
+		o.Ref = ref
+	}
+}
+
+// WithDefaultContext sets the context of the GitHub API calls made by New
+// and by the filesystem it returns, unless a view made with WithContext is
+// used.
+func WithDefaultContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Context = ctx
+	}
+}
+
+// WithAuthor sets the author of the commits made by the filesystem.
+func WithAuthor(name, email string) Option {
+	return func(o *Options) {
+		o.Commit.Author = &github.CommitAuthor{Name: String(name), Email: String(email)}
+	}
+}
+
+// WithCommitter sets the committer of the commits made by the filesystem.
+func WithCommitter(name, email string) Option {
+	return func(o *Options) {
+		o.Commit.Committer = &github.CommitAuthor{Name: String(name), Email: String(email)}
+	}
+}
+
+// WithCommitMessage sets the commit message template. See CommitOptions.
+func WithCommitMessage(template string) Option {
+	return func(o *Options) {
+		o.Commit.Message = template
+	}
+}
+
+// WithReadOnly makes every mutating operation fail with os.ErrPermission.
+func WithReadOnly() Option {
+	return func(o *Options) {
+		o.ReadOnly = true
+	}
+}
+
+// WithLazyTrees lists each directory on first access instead of listing the
+// whole repository up front.
+func WithLazyTrees() Option {
+	return func(o *Options) {
+		o.LazyTrees = true
+	}
+}
+
+// WithBlobCache sets the cache for blob contents.
+func WithBlobCache(cache BlobCache) Option {
+	return func(o *Options) {
+		o.Cache = cache
+	}
+}
+
+// WithBaseURL sends requests to the API of a GitHub Enterprise server at
+// url with httpClient, which carries their authentication. The client given
+// to New is left unchanged.
+func WithBaseURL(url string, httpClient *http.Client) Option {
+	return func(o *Options) {
+		o.BaseURL = url
+		o.HTTPClient = httpClient
+	}
+}
+
+// WithLogger sets the logger for commits and retries.
+func WithLogger(logger Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithSubmodules mounts submodules as read-only filesystems of their
+// repositories.
+func WithSubmodules() Option {
+	return func(o *Options) {
+		o.Submodules = true
+	}
+}
+
+// WithModTimes sets where the modification times of files come from.
+func WithModTimes(policy ModTimePolicy) Option {
+	return func(o *Options) {
+		o.ModTimes = policy
+	}
+}
+
+// WithPlaceholder keeps empty directories by writing an empty file with the
+// given name to them, such as ".gitkeep".
+func WithPlaceholder(name string) Option {
+	return func(o *Options) {
+		o.Placeholder = name
+	}
+}
+
+// WithStreaming reads files of at least threshold bytes with range requests
+// instead of holding them in memory.
+func WithStreaming(threshold int64) Option {
+	return func(o *Options) {
+		o.StreamThreshold = threshold
+	}
+}
+
+// WithConflictPolicy sets what happens when the branch has moved since the
+// filesystem last synced with it.
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(o *Options) {
+		o.ConflictPolicy = policy
+	}
+}
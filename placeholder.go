@@ -0,0 +1,51 @@
+package githubfs
+
+import (
+	"path"
+
+	"github.com/google/go-github/github"
+)
+
+// isPlaceholder reports whether p is the placeholder file of a directory.
+func (fs *githubFs) isPlaceholder(p string) bool {
+	return fs.placeholder != "" && path.Base(p) == fs.placeholder
+}
+
+// addPlaceholder adds the placeholder file to the directory dir, so it is
+// kept when committed.
+func (fs *githubFs) addPlaceholder(dir string) error {
+	sha, err := fs.emptyBlob()
+	if err != nil {
+		return err
+	}
+	fs.indexed().append(github.TreeEntry{
+		Type: String("blob"),
+		Mode: String("100644"),
+		Path: String(path.Join(dir, fs.placeholder)),
+		SHA:  String(sha),
+		Size: github.Int(0),
+	})
+	return nil
+}
+
+// dropPlaceholder removes the placeholder file of the directory dir once
+// something else is stored in it, and reports whether it did.
+func (fs *githubFs) dropPlaceholder(dir string) bool {
+	if fs.placeholder == "" {
+		return false
+	}
+	p := path.Join(dir, fs.placeholder)
+	idx := fs.indexed()
+	i, ok := idx.pos(p)
+	if !ok {
+		return false
+	}
+	for _, e := range idx.list(dir) {
+		if e.GetPath() != p && e.SHA != nil {
+			fs.tree.Entries = append(fs.tree.Entries[:i], fs.tree.Entries[i+1:]...)
+			fs.index = nil
+			return true
+		}
+	}
+	return false
+}
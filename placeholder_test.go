@@ -0,0 +1,67 @@
+package githubfs_test
+
+import (
+	"testing"
+
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/spf13/afero"
+)
+
+func TestPlaceholder(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		opts = append(opts, githubfs.WithPlaceholder(".gitkeep"))
+		srv, fs := newTestFs(t, opts...)
+		if err := fs.MkdirAll("x/y/z", 0755); err != nil {
+			t.Fatal(err)
+		}
+		files := headFiles(srv)
+		if _, ok := files["x/y/z/.gitkeep"]; !ok || len(files) != 4 {
+			t.Fatalf("unexpected files %v", files)
+		}
+
+		// a fresh mount sees the directory but not its placeholder
+		fs, err := githubfs.New(srv.Client(), "o", "r", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := fs.Stat("x/y/z"); err != nil || !fi.IsDir() {
+			t.Fatalf("stat: %v %v", fi, err)
+		}
+		if infos, err := afero.ReadDir(fs, "x/y/z"); err != nil || len(infos) != 0 {
+			t.Fatalf("readdir: %v %v", infos, err)
+		}
+
+		if err := afero.WriteFile(fs, "x/y/z/f.txt", []byte("f"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = headFiles(srv)
+		if _, ok := files["x/y/z/.gitkeep"]; ok || files["x/y/z/f.txt"] != "f" {
+			t.Fatalf("unexpected files %v", files)
+		}
+		if err := fs.Remove("x/y/z/f.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := headFiles(srv)["x/y/z/.gitkeep"]; !ok {
+			t.Fatal("emptied directory lost its placeholder")
+		}
+
+		// reloading keeps the directories made since the mount
+		srv.Commit("o", "r", "master", map[string]string{"remote.txt": "r"})
+		if err := githubfs.Reload(fs); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := fs.Stat("x/y/z"); err != nil || !fi.IsDir() {
+			t.Fatalf("stat after reload: %v %v", fi, err)
+		}
+		if infos, err := afero.ReadDir(fs, "x/y/z"); err != nil || len(infos) != 0 {
+			t.Fatalf("readdir after reload: %v %v", infos, err)
+		}
+		if err := fs.Remove("x/y/z"); err != nil {
+			t.Fatal(err)
+		}
+		files = headFiles(srv)
+		if _, ok := files["x/y/.gitkeep"]; !ok || files["remote.txt"] != "r" || len(files) != 5 {
+			t.Fatalf("unexpected files %v", files)
+		}
+	}
+}
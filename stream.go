@@ -0,0 +1,192 @@
+package githubfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// rawMediaType asks the API for the contents of a blob as they are,
+// instead of base64 encoded in JSON.
+const rawMediaType = "application/vnd.github.raw"
+
+var (
+	// errRangeFull stops a download once the requested range is read.
+	errRangeFull = errors.New("range read")
+
+	// errNoRanges is returned by a blobReader when the server doesn't
+	// support range requests, so the blob has to be fetched whole.
+	errNoRanges = errors.New("range requests are not supported")
+)
+
+// rangeSupport records whether the server honors range requests, which is
+// checked once per filesystem.
+type rangeSupport int
+
+const (
+	rangesUnknown rangeSupport = iota
+	rangesSupported
+	rangesUnsupported
+)
+
+// rangeWriter keeps the start of a response body in buf, and fails once
+// buf is full so the rest isn't downloaded.
+type rangeWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	w.n += copy(w.buf[w.n:], p)
+	if w.n == len(w.buf) {
+		return len(p), errRangeFull
+	}
+	return len(p), nil
+}
+
+// blobRequest returns a request for the raw contents of the blob sha.
+func (fs *githubFs) blobRequest(sha string) (*http.Request, error) {
+	u := fmt.Sprintf("repos/%v/%v/git/blobs/%v", fs.user, fs.repo, sha)
+	req, err := fs.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", rawMediaType)
+	return req, nil
+}
+
+// readRaw fetches the contents of the blob sha as they are, which avoids
+// decoding large blobs from base64.
+func (fs *githubFs) readRaw(sha string) ([]byte, error) {
+	if fs.cache != nil {
+		if data, ok := fs.cache.Get(sha); ok {
+			return data, nil
+		}
+	}
+	req, err := fs.blobRequest(sha)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := fs.client.Do(fs.ctx, req, &buf); err != nil {
+		return nil, err
+	}
+	if fs.cache != nil {
+		fs.cache.Add(sha, buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// rangesSupported reports whether the server honors range requests. The
+// first call asks for the first byte of the blob sha and checks the status
+// of the reply.
+func (fs *githubFs) rangesSupported(sha string) (bool, error) {
+	fs.rangesMu.Lock()
+	defer fs.rangesMu.Unlock()
+	if fs.ranges == rangesUnknown {
+		req, err := fs.blobRequest(sha)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err := fs.client.Do(fs.ctx, req, &rangeWriter{buf: make([]byte, 1)})
+		if err != nil {
+			return false, err
+		}
+		fs.ranges = rangesUnsupported
+		if resp.StatusCode == http.StatusPartialContent {
+			fs.ranges = rangesSupported
+		}
+	}
+	return fs.ranges == rangesSupported, nil
+}
+
+// blobReader reads a blob with range requests. Sequential reads share one
+// response, a new one is only requested when reading from another offset.
+type blobReader struct {
+	fs   *githubFs
+	sha  string
+	size int64
+
+	// body is the rest of the blob from off on.
+	body *io.PipeReader
+	off  int64
+
+	// data is the whole blob if it was found in the cache.
+	data []byte
+}
+
+// ReadAt reads from the blob at off. It returns errNoRanges if the server
+// doesn't support range requests.
+func (r *blobReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-off {
+		p = p[:r.size-off]
+	}
+	if r.data == nil && (r.body == nil || r.off != off) {
+		if err := r.open(off); err != nil {
+			return 0, err
+		}
+	}
+	if r.data != nil {
+		return copy(p, r.data[off:]), nil
+	}
+	n, err := io.ReadFull(r.body, p)
+	r.off += int64(n)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// the blob is shorter than its size said
+		r.Close()
+		if n > 0 {
+			err = nil
+		} else {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// open requests the blob from off on, unless it is cached.
+func (r *blobReader) open(off int64) error {
+	r.Close()
+	if r.fs.cache != nil {
+		if data, ok := r.fs.cache.Get(r.sha); ok {
+			r.data = data
+			return nil
+		}
+	}
+	ok, err := r.fs.rangesSupported(r.sha)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoRanges
+	}
+	req, err := r.fs.blobRequest(r.sha)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	pr, pw := io.Pipe()
+	// the response is copied into the pipe as it is read, and the copy
+	// stops when the pipe is closed
+	go func() {
+		_, err := r.fs.client.Do(r.fs.ctx, req, pw)
+		pw.CloseWithError(err)
+	}()
+	r.body = pr
+	r.off = off
+	return nil
+}
+
+// Close stops the current response, if any.
+func (r *blobReader) Close() error {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	return nil
+}
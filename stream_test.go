@@ -0,0 +1,188 @@
+package githubfs_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/progrium/go-githubfs/githubfstest"
+	"github.com/spf13/afero"
+)
+
+// countingTransport counts requests and the response bytes read. With
+// noRanges set it drops Range headers, like a server that ignores them.
+type countingTransport struct {
+	requests int64
+	bytes    int64
+	noRanges bool
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.noRanges {
+		req.Header.Del("Range")
+	}
+	atomic.AddInt64(&c.requests, 1)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingBody{resp.Body, &c.bytes}
+	return resp, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.n, int64(n))
+	return n, err
+}
+
+func TestStream(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 200000)
+	for _, noRanges := range []bool{false, true} {
+		srv := githubfstest.NewServer()
+		defer srv.Close()
+		srv.CreateRepo("o", "r", "master", map[string]string{"big.bin": string(big), "small": "s"})
+		c := &countingTransport{noRanges: noRanges}
+		client := github.NewClient(&http.Client{Transport: c})
+		client.BaseURL, _ = url.Parse(srv.URL + "/")
+		fs, err := githubfs.New(client, "o", "r", githubfs.WithStreaming(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Open("big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests, read := atomic.LoadInt64(&c.requests), atomic.LoadInt64(&c.bytes)
+		buf := make([]byte, 5)
+		if n, err := f.ReadAt(buf, 1234); n != 5 || err != nil || string(buf) != "45678" {
+			t.Fatalf("read at: %d %v %q", n, err, buf)
+		}
+		if !noRanges && atomic.LoadInt64(&c.bytes)-read >= int64(len(big)) {
+			t.Fatal("read of a range fetched the whole blob")
+		}
+		if _, err := f.Seek(-3, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := f.Read(buf); n != 3 || err != nil || string(buf[:3]) != "789" {
+			t.Fatalf("read at end: %d %v", n, err)
+		}
+		if n, err := f.Read(buf); n != 0 || err != io.EOF {
+			t.Fatalf("read past end: %d %v", n, err)
+		}
+
+		// sequential reads share one response
+		f.Seek(0, io.SeekStart)
+		requests = atomic.LoadInt64(&c.requests)
+		all, err := ioutil.ReadAll(f)
+		if err != nil || !bytes.Equal(all, big) {
+			t.Fatalf("read all: %d bytes, %v", len(all), err)
+		}
+		if n := atomic.LoadInt64(&c.requests) - requests; n > 1 {
+			t.Fatalf("sequential read made %d requests", n)
+		}
+		f.Close()
+
+		// writing fetches the whole blob
+		wf, err := fs.OpenFile("big.bin", os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wf.WriteAt([]byte("X"), 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := wf.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := headFiles(srv)["big.bin"]; len(got) != len(big) || got[:3] != "0X2" {
+			t.Fatalf("wrote %d bytes starting %q", len(got), got[:3])
+		}
+		if got := readFile(t, fs, "small"); got != "s" {
+			t.Fatalf("read %q", got)
+		}
+	}
+}
+
+func TestStreamCache(t *testing.T) {
+	srv := githubfstest.NewServer()
+	defer srv.Close()
+	srv.CreateRepo("o", "r", "master", map[string]string{"big.bin": string(bytes.Repeat([]byte("0123456789"), 1000))})
+	fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithStreaming(1000), githubfs.WithBlobCache(githubfs.NewLRUBlobCache(1<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the written blob is cached
+	wf, err := fs.OpenFile("big.bin", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wf.WriteAt([]byte("X"), 5001); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n := srv.Requests()
+	f, err := fs.Open("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 3)
+	if _, err := f.ReadAt(buf, 5000); err != nil || string(buf) != "0X2" {
+		t.Fatalf("read %q: %v", buf, err)
+	}
+	if srv.Requests() != n {
+		t.Fatalf("cached blob made %d requests", srv.Requests()-n)
+	}
+}
+
+func TestStreamWhileWriting(t *testing.T) {
+	srv := githubfstest.NewServer()
+	defer srv.Close()
+	srv.CreateRepo("o", "r", "master", map[string]string{"big.bin": string(bytes.Repeat([]byte("0123456789"), 4<<20))})
+	fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithStreaming(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Open("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 5)
+	if _, err := f.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rest of big.bin is still being served, more than fits in the
+	// socket buffers
+	done := make(chan error, 1)
+	go func() {
+		done <- afero.WriteFile(fs, "small.txt", []byte("small"), 0644)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("write blocked on the open stream")
+	}
+	if headFiles(srv)["small.txt"] != "small" {
+		t.Fatal("write wasn't committed")
+	}
+}
@@ -0,0 +1,164 @@
+package githubfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// submodule returns the filesystem of the submodule containing the path
+// name, and the path of name inside of it. It returns nil if name isn't
+// inside a submodule or submodules aren't mounted. The entries of name's
+// ancestors must have been looked up already.
+func (fs *githubFs) submodule(name string) (*githubFs, string, error) {
+	if !fs.submodules || name == "" {
+		return nil, "", nil
+	}
+	elems := strings.Split(name, "/")
+	for i := range elems {
+		p := strings.Join(elems[:i+1], "/")
+		e := fs.findEntry(p)
+		if e == nil {
+			return nil, "", nil
+		}
+		if e.GetType() != "commit" {
+			continue
+		}
+		sub, err := fs.mount(p, e.GetSHA())
+		if err != nil {
+			return nil, "", err
+		}
+		// API calls use the context of fs
+		view := *sub
+		view.ctx = fs.ctx
+		return &view, strings.Join(elems[i+1:], "/"), nil
+	}
+	return nil, "", nil
+}
+
+// mount returns the read-only filesystem of the submodule at p pinned to
+// the commit sha. The repository is looked up in .gitmodules.
+func (fs *githubFs) mount(p string, sha string) (*githubFs, error) {
+	key := p + "@" + sha
+	if sub, ok := fs.mounts[key]; ok {
+		return sub, nil
+	}
+	e := fs.findEntry(".gitmodules")
+	if e == nil {
+		return nil, fmt.Errorf("submodule %s is not in .gitmodules", p)
+	}
+	data, err := fs.readBlob(e.GetSHA())
+	if err != nil {
+		return nil, err
+	}
+	u, ok := parseGitmodules(data)[p]
+	if !ok {
+		return nil, fmt.Errorf("submodule %s is not in .gitmodules", p)
+	}
+	owner, repo, ok := fs.repoFromURL(u)
+	if !ok {
+		return nil, fmt.Errorf("submodule %s has unsupported url %s, only repositories on %s can be mounted", p, u, fs.gitHost())
+	}
+	sub, err := newGitHubFs(fs.client, owner, repo, Options{
+		Ref:        sha,
+		Context:    fs.ctx,
+		LazyTrees:  fs.lazy,
+		Cache:      fs.cache,
+		Logger:     fs.logger,
+		Submodules: true,
+		ModTimes:   fs.modTimes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fs.mounts[key] = sub.(*githubFs)
+	return sub.(*githubFs), nil
+}
+
+// parseGitmodules maps the paths of the submodules in a .gitmodules file
+// to their urls.
+func parseGitmodules(data []byte) map[string]string {
+	urls := make(map[string]string)
+	var p, u string
+	flush := func() {
+		if p != "" && u != "" {
+			urls[p] = u
+		}
+		p, u = "", ""
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") {
+			flush()
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "path":
+			p = strings.Trim(strings.TrimSpace(kv[1]), "/")
+		case "url":
+			u = strings.TrimSpace(kv[1])
+		}
+	}
+	flush()
+	return urls
+}
+
+// repoFromURL returns the owner and name of the repository at the url of
+// a submodule. Urls relative to the repository are supported, absolute
+// urls only if they are on the host of the API the client talks to, so a
+// submodule hosted elsewhere isn't mistaken for an unrelated repository of
+// the same name.
+func (fs *githubFs) repoFromURL(u string) (string, string, bool) {
+	var host, p string
+	switch {
+	case strings.HasPrefix(u, "./") || strings.HasPrefix(u, "../"):
+		return splitRepo(path.Join(fs.user, fs.repo, u))
+	case strings.Contains(u, "://"):
+		pu, err := url.Parse(u)
+		if err != nil {
+			return "", "", false
+		}
+		host, p = pu.Hostname(), pu.Path
+	case strings.Contains(u, ":"):
+		// scp-like syntax, git@github.com:owner/repo.git
+		i := strings.Index(u, ":")
+		host, p = u[:i], u[i+1:]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+	default:
+		return "", "", false
+	}
+	if !strings.EqualFold(host, fs.gitHost()) {
+		return "", "", false
+	}
+	return splitRepo(p)
+}
+
+// splitRepo splits an owner/name path, as found in repository urls.
+func splitRepo(p string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSuffix(strings.Trim(p, "/"), ".git"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// gitHost returns the host repositories are cloned from for the API the
+// client talks to: github.com for api.github.com, and the host of the
+// BaseURL of a GitHub Enterprise server.
+func (fs *githubFs) gitHost() string {
+	host := fs.client.BaseURL.Hostname()
+	if host == "api.github.com" {
+		return "github.com"
+	}
+	return host
+}
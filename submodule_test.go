@@ -0,0 +1,146 @@
+package githubfs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/progrium/go-githubfs/githubfstest"
+	"github.com/spf13/afero"
+)
+
+// addSubmodule commits a submodule at vendor/lib pinned to sha, with url in
+// .gitmodules, to master.
+func addSubmodule(t *testing.T, srv *githubfstest.Server, url, sha string) {
+	t.Helper()
+	ctx := context.Background()
+	c := srv.Client()
+	srv.Commit("o", "r", "master", map[string]string{
+		".gitmodules": fmt.Sprintf("[submodule \"vendor/lib\"]\n\tpath = vendor/lib\n\turl = %s\n", url),
+		"vendor/keep": "",
+	})
+	branch, _, err := c.Repositories.GetBranch(ctx, "o", "r", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _, err := c.Git.CreateTree(ctx, "o", "r", branch.GetCommit().GetCommit().GetTree().GetSHA(), []github.TreeEntry{{
+		Path: github.String("vendor/lib"),
+		Mode: github.String("160000"),
+		Type: github.String("commit"),
+		SHA:  github.String(sha),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, _, err := c.Git.CreateCommit(ctx, "o", "r", &github.Commit{
+		Message: github.String("add submodule"),
+		Tree:    tree,
+		Parents: []github.Commit{{SHA: branch.GetCommit().SHA}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := &github.Reference{Ref: github.String("heads/master"), Object: &github.GitObject{SHA: commit.SHA}}
+	if _, _, err := c.Git.UpdateRef(ctx, "o", "r", ref, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmodule(t *testing.T) {
+	srv, _ := newTestFs(t)
+	sha := srv.CreateRepo("o", "lib", "main", map[string]string{"lib.go": "package lib", "x/y.txt": "why"})
+	addSubmodule(t, srv, "../lib.git", sha)
+
+	fs, err := githubfs.New(srv.Client(), "o", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("vendor/lib")
+	if err != nil || !fi.IsDir() || fi.Mode()&os.ModeIrregular == 0 {
+		t.Fatalf("stat: %v %v", fi, err)
+	}
+	if info := fi.Sys().(*githubfs.EntryInfo); info.SHA != sha || info.Type != "commit" {
+		t.Fatalf("sys %+v", info)
+	}
+	if _, err := fs.Open("vendor/lib/lib.go"); !os.IsNotExist(err) {
+		t.Fatalf("open without mounting: %v", err)
+	}
+
+	for _, opts := range [][]githubfs.Option{{githubfs.WithSubmodules()}, {githubfs.WithSubmodules(), githubfs.WithLazyTrees()}} {
+		fs, err := githubfs.New(srv.Client(), "o", "r", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, fs, "vendor/lib/x/y.txt"); got != "why" {
+			t.Fatalf("read %q", got)
+		}
+		if infos, err := afero.ReadDir(fs, "vendor/lib"); err != nil || len(infos) != 2 {
+			t.Fatalf("readdir: %v %v", infos, err)
+		}
+		if fi, err := fs.Stat("vendor/lib/lib.go"); err != nil || fi.Size() != 11 {
+			t.Fatalf("stat: %v %v", fi, err)
+		}
+		if err := afero.WriteFile(fs, "vendor/lib/lib.go", []byte("no"), 0644); err == nil {
+			t.Fatal("wrote to a submodule")
+		}
+	}
+}
+
+func TestSubmoduleContext(t *testing.T) {
+	srv, _ := newTestFs(t)
+	sha := srv.CreateRepo("o", "lib", "main", map[string]string{"lib.go": "package lib"})
+	addSubmodule(t, srv, "../lib.git", sha)
+	fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithSubmodules(), githubfs.WithBlobCache(githubfs.NewLRUBlobCache(1<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// .gitmodules is cached, so only the mount makes requests
+	readFile(t, fs, ".gitmodules")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	view, err := githubfs.WithContext(fs, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mounting the submodule uses the context of the view
+	if _, err := view.Stat("vendor/lib/lib.go"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("stat with a canceled context: %v", err)
+	}
+	if got := readFile(t, fs, "vendor/lib/lib.go"); got != "package lib" {
+		t.Fatalf("read %q", got)
+	}
+}
+
+func TestSubmoduleHost(t *testing.T) {
+	for _, tt := range []struct {
+		url   string
+		mount bool
+	}{
+		{"SERVER/o/lib.git", true},
+		{"https://gitlab.com/o/lib.git", false},
+		{"git@bitbucket.org:o/lib", false},
+		{"git@github.com:o/lib.git", false},
+	} {
+		srv, _ := newTestFs(t)
+		sha := srv.CreateRepo("o", "lib", "main", map[string]string{"lib.go": "package lib"})
+		// the fake server is the API host, and o/lib exists on it
+		url := strings.Replace(tt.url, "SERVER", srv.URL, 1)
+		addSubmodule(t, srv, url, sha)
+		fs, err := githubfs.New(srv.Client(), "o", "r", githubfs.WithSubmodules())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = afero.ReadFile(fs, "vendor/lib/lib.go")
+		if tt.mount && err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		if !tt.mount && err == nil {
+			t.Fatalf("%s was mounted from the API host", url)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package githubfs
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/afero"
+)
+
+// maxSymlinks is the number of symlinks followed when resolving a path
+// before giving up, like Linux does.
+const maxSymlinks = 40
+
+// ErrSymlinkLoop is returned when resolving a path follows too many
+// symlinks, most likely because they form a loop.
+var ErrSymlinkLoop = errors.New("too many levels of symbolic links")
+
+// resolve follows the symlinks in name and returns the path it refers to,
+// relative to the root. The last element is only followed if follow is
+// set. Symlinks are resolved within the repository, so absolute targets
+// and targets above the root can't be followed. Elements that don't exist
+// are left as they are.
+func (fs *githubFs) resolve(name string, follow bool) (string, error) {
+	rest := strings.Split(strings.Trim(name, "/"), "/")
+	cur := ""
+	links := 0
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if cur == "" {
+				return "", &os.PathError{Op: "resolve", Path: name, Err: os.ErrNotExist}
+			}
+			cur = parentDir(cur)
+			continue
+		}
+		p := path.Join(cur, elem)
+		e, err := fs.lookup(p)
+		if err != nil {
+			return "", err
+		}
+		if e == nil {
+			return path.Join(append([]string{p}, rest...)...), nil
+		}
+		if e.GetMode() != "120000" || (len(rest) == 0 && !follow) {
+			cur = p
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: name, Err: ErrSymlinkLoop}
+		}
+		target, err := fs.readBlob(e.GetSHA())
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(string(target)) {
+			return "", &os.PathError{Op: "resolve", Path: name, Err: os.ErrNotExist}
+		}
+		rest = append(strings.Split(string(target), "/"), rest...)
+	}
+	return cur, nil
+}
+
+// SymlinkIfPossible creates newname as a symlink to oldname. The target is
+// stored as is and is only resolved when the link is followed.
+func (fs *githubFs) SymlinkIfPossible(oldname, newname string) error {
+	if fs.readOnly {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrPermission}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	normalName, err := fs.resolve(newname, false)
+	if err != nil {
+		return err
+	}
+	if normalName == "" {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	e, err := fs.lookup(normalName)
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	parent := parentDir(normalName)
+	if parent != "" && fs.findEntry(parent).GetType() != "tree" {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	blob, _, err := fs.client.Git.CreateBlob(fs.ctx, fs.user, fs.repo, &github.Blob{
+		Content:  String(base64.StdEncoding.EncodeToString([]byte(oldname))),
+		Encoding: String("base64"),
+	})
+	if err != nil {
+		return err
+	}
+	if fs.cache != nil {
+		fs.cache.Add(blob.GetSHA(), []byte(oldname))
+	}
+	restore := fs.snapshot()
+	fs.indexed().append(github.TreeEntry{
+		Type: String("blob"),
+		Mode: String("120000"),
+		Path: String(normalName),
+		SHA:  blob.SHA,
+		Size: github.Int(len(oldname)),
+	})
+	if parent != "" {
+		if err := fs.createTreesFromEntries(parent, true); err != nil {
+			restore()
+			return err
+		}
+	}
+	if err := fs.commit("symlink", normalName); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// ReadlinkIfPossible returns the target of the symlink name.
+func (fs *githubFs) ReadlinkIfPossible(name string) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	normalName, err := fs.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	e, err := fs.lookup(normalName)
+	if err != nil {
+		return "", err
+	}
+	if e == nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrFileNotFound}
+	}
+	if e.GetMode() != "120000" {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+	target, err := fs.readBlob(e.GetSHA())
+	if err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
+// LstatIfPossible is like Stat, but describes symlinks instead of the
+// files they point to.
+func (fs *githubFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fi, err := fs.stat(name, false)
+	return fi, true, err
+}
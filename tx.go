@@ -0,0 +1,128 @@
+package githubfs
+
+import (
+	"errors"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/afero"
+)
+
+var (
+	ErrNotGitHubFs  = errors.New("filesystem is not a githubfs filesystem")
+	ErrTxInProgress = errors.New("a transaction is already in progress")
+	ErrTxDone       = errors.New("transaction has already been committed or rolled back")
+)
+
+// Tx is a transaction against a githubfs filesystem. While a transaction is
+// open, every change made to the filesystem is staged against the in-memory
+// tree instead of being committed, and Commit publishes all of them as a
+// single commit. Tx implements afero.Fs, so it can be handed to code that
+// expects a filesystem.
+type Tx struct {
+	afero.Fs
+
+	fs     *githubFs
+	tree   *github.Tree
+	base   *github.Tree
+	loaded map[string]bool
+	paths  []string
+	dirty  bool
+	done   bool
+
+	// dirs are the directories whose trees are rebuilt on Commit.
+	dirs map[string]bool
+
+	// rolledBack is set by Rollback. Handles opened during the
+	// transaction can't be synced afterwards.
+	rolledBack bool
+}
+
+// Begin starts a transaction on fs, which must have been created by this
+// package. Only one transaction can be open on a filesystem at a time.
+func Begin(fs afero.Fs) (*Tx, error) {
+	gfs, ok := asGitHubFs(fs)
+	if !ok {
+		return nil, ErrNotGitHubFs
+	}
+	gfs.mu.Lock()
+	defer gfs.mu.Unlock()
+	if gfs.tx != nil {
+		return nil, ErrTxInProgress
+	}
+	tx := &Tx{Fs: gfs, fs: gfs, tree: copyTree(gfs.tree), base: copyTree(gfs.base), dirs: make(map[string]bool)}
+	if gfs.loaded != nil {
+		tx.loaded = make(map[string]bool)
+		for d := range gfs.loaded {
+			tx.loaded[d] = true
+		}
+	}
+	gfs.tx = tx
+	return tx, nil
+}
+
+// Commit publishes the staged changes as a single commit with the given
+// message. If message is empty, the filesystem's message template is used
+// with the "transaction" op and every path touched. If nothing was staged no
+// commit is made. If publishing fails the transaction stays open so it can
+// be retried or rolled back.
+func (tx *Tx) Commit(message string) error {
+	tx.fs.mu.Lock()
+	defer tx.fs.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	if message == "" {
+		var err error
+		message, err = tx.fs.commitOpts.message(CommitInfo{Op: "transaction", Paths: uniquePaths(tx.paths)})
+		if err != nil {
+			return err
+		}
+	}
+	if tx.dirty {
+		tx.fs.tx = nil
+		if err := tx.fs.rebuildTrees(tx.dirs); err != nil {
+			tx.fs.tx = tx
+			return err
+		}
+		if err := tx.fs.publish(message); err != nil {
+			tx.fs.tx = tx
+			return err
+		}
+	}
+	tx.fs.tx = nil
+	tx.done = true
+	return nil
+}
+
+// Rollback discards the staged changes and restores the tree the
+// transaction started from. Files opened during the transaction fail with
+// ErrTxDone when synced or closed afterwards, instead of committing.
+func (tx *Tx) Rollback() error {
+	tx.fs.mu.Lock()
+	defer tx.fs.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.fs.tree = tx.tree
+	tx.fs.base = tx.base
+	tx.fs.loaded = tx.loaded
+	tx.fs.tx = nil
+	tx.paths = nil
+	tx.done = true
+	tx.rolledBack = true
+	return nil
+}
+
+// uniquePaths returns paths without repeats, in the order they first
+// appear.
+func uniquePaths(paths []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, p := range paths {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	return unique
+}
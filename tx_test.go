@@ -0,0 +1,159 @@
+package githubfs_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	githubfs "github.com/progrium/go-githubfs"
+	"github.com/spf13/afero"
+)
+
+func TestTxCommit(t *testing.T) {
+	for _, opts := range [][]githubfs.Option{nil, {githubfs.WithLazyTrees()}} {
+		srv, fs := newTestFs(t, opts...)
+		head := srv.Head("o", "r", "master")
+		tx, err := githubfs.Begin(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.MkdirAll("x/y", 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := afero.WriteFile(tx, fmt.Sprintf("x/y/%d", i), []byte(fmt.Sprint(i)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Remove("dir/b.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, tx, "x/y/3"); got != "3" {
+			t.Fatalf("read %q in transaction", got)
+		}
+		if srv.Head("o", "r", "master") != head {
+			t.Fatal("transaction committed before Commit")
+		}
+		if _, err := githubfs.Begin(fs); err != githubfs.ErrTxInProgress {
+			t.Fatalf("nested begin: %v", err)
+		}
+		if err := tx.Commit("batch"); err != nil {
+			t.Fatal(err)
+		}
+		h := srv.Head("o", "r", "master")
+		if msg := srv.Message("o", "r", h); msg != "batch" {
+			t.Fatalf("commit message %q", msg)
+		}
+		files := headFiles(srv)
+		if len(files) != 12 || files["x/y/9"] != "9" || files["dir/sub/c.txt"] != "see" {
+			t.Fatalf("unexpected files %v", files)
+		}
+		if _, ok := files["dir/b.txt"]; ok {
+			t.Fatal("removed file was committed")
+		}
+		if err := tx.Commit(""); err != githubfs.ErrTxDone {
+			t.Fatalf("second commit: %v", err)
+		}
+		if err := afero.WriteFile(fs, "after.txt", []byte("after"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if headFiles(srv)["after.txt"] != "after" {
+			t.Fatal("write after commit wasn't committed")
+		}
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	srv, fs := newTestFs(t)
+	head := srv.Head("o", "r", "master")
+	tx, err := githubfs.Begin(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(tx, "a.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.RemoveAll("dir"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := tx.OpenFile("open.txt", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("pending")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != githubfs.ErrTxDone {
+		t.Fatalf("close after rollback: %v", err)
+	}
+	if srv.Head("o", "r", "master") != head {
+		t.Fatal("rolled back transaction was committed")
+	}
+	if got := readFile(t, fs, "a.txt"); got != "hello" {
+		t.Fatalf("read %q after rollback", got)
+	}
+	if got := readFile(t, fs, "dir/sub/c.txt"); got != "see" {
+		t.Fatalf("read %q after rollback", got)
+	}
+	if _, err := fs.Stat("open.txt"); !os.IsNotExist(err) {
+		t.Fatalf("stat after rollback: %v", err)
+	}
+	if err := tx.Commit(""); err != githubfs.ErrTxDone {
+		t.Fatalf("commit after rollback: %v", err)
+	}
+}
+
+func TestTxCommitMessage(t *testing.T) {
+	srv, fs := newTestFs(t, githubfs.WithCommitMessage(`{{.Op}} {{join .Paths ", "}}`))
+	tx, err := githubfs.Begin(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "new.txt", "a.txt"} {
+		if err := afero.WriteFile(tx, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(""); err != nil {
+		t.Fatal(err)
+	}
+	if msg := srv.Message("o", "r", srv.Head("o", "r", "master")); msg != "transaction a.txt, new.txt" {
+		t.Fatalf("commit message %q", msg)
+	}
+}
+
+func TestTxRollbackWhileClosing(t *testing.T) {
+	srv, fs := newTestFs(t)
+	head := srv.Head("o", "r", "master")
+	tx, err := githubfs.Begin(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := tx.OpenFile("a.txt", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- f.Close()
+	}()
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	// the file is either staged and rolled back, or closed too late
+	if err := <-done; err != nil && err != githubfs.ErrTxDone {
+		t.Fatalf("close: %v", err)
+	}
+	if srv.Head("o", "r", "master") != head {
+		t.Fatal("file closed during rollback was committed")
+	}
+	if got := readFile(t, fs, "a.txt"); got != "hello" {
+		t.Fatalf("read %q after rollback", got)
+	}
+}